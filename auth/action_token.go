@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	"github.com/quocdaitrn/golang-kit/internal/sweep"
+)
+
+// actionTokenType is the typ header of action tokens and actionTokenAudience
+// their audience. Access token parsing rejects both so that an action token,
+// signed with the same keys, can't be used as a bearer token. The audience
+// also keeps them out of services verifying access tokens with another
+// library.
+const (
+	actionTokenType     = "action+jwt"
+	actionTokenAudience = "urn:golang-kit:action-token"
+)
+
+var (
+	ErrActionTokenType    = kiterrors.New("action tokens are not access tokens")
+	ErrActionPurposeEmpty = kiterrors.New("action token purpose must be specified")
+)
+
+// ActionClaims are the claims of an action token.
+type ActionClaims struct {
+	jwt.RegisteredClaims
+
+	// Purpose is the action the token is bound to, such as "verify-email"
+	// or "reset-password".
+	Purpose string `json:"pur"`
+
+	// Data carries values of the action, such as the email address being
+	// verified.
+	Data map[string]string `json:"dat,omitempty"`
+
+	// PasswordStamp fingerprints the password change time of the subject
+	// when the token was issued.
+	PasswordStamp string `json:"pws,omitempty"`
+}
+
+// ConsumedTokenStore records consumed single-use tokens by id.
+type ConsumedTokenStore interface {
+	// Consume marks the token jti as consumed and reports false if it
+	// already was. The record may be dropped after expiresAt.
+	Consume(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+// PasswordChangedFunc returns when the password of sub last changed, the zero
+// time if never.
+type PasswordChangedFunc func(ctx context.Context, sub string) (time.Time, error)
+
+// ActionTokenOption configures an ActionTokenManager.
+type ActionTokenOption func(*ActionTokenManager)
+
+// WithConsumedTokenStore makes tokens single-use: Consume fails with
+// ErrTokenBlacklisted for a token consumed before.
+func WithConsumedTokenStore(s ConsumedTokenStore) ActionTokenOption {
+	return func(m *ActionTokenManager) {
+		m.consumed = s
+	}
+}
+
+// WithPasswordChangedFunc invalidates the tokens of a subject issued before
+// its password changed, the usual requirement for password reset links.
+func WithPasswordChangedFunc(fn PasswordChangedFunc) ActionTokenOption {
+	return func(m *ActionTokenManager) {
+		m.passwordChanged = fn
+	}
+}
+
+// keyRingProvider is implemented by providers holding signing keys.
+type keyRingProvider interface {
+	keys() (*keyRing, error)
+}
+
+// ActionTokenManager issues and verifies signed tokens bound to a purpose,
+// for links sent by email such as email verification and password reset.
+type ActionTokenManager struct {
+	keys            func() (*keyRing, error)
+	consumed        ConsumedTokenStore
+	passwordChanged PasswordChangedFunc
+}
+
+// NewActionTokenManager creates an ActionTokenManager signing with the active
+// key of p, a provider created by NewJWT.
+func NewActionTokenManager(p ClaimsProvider, opts ...ActionTokenOption) (*ActionTokenManager, error) {
+	kp, ok := p.(keyRingProvider)
+	if !ok {
+		return nil, kiterrors.WithStack(ErrSigningKeyMissing)
+	}
+
+	return newActionTokenManager(kp.keys, opts), nil
+}
+
+// NewHMACActionTokenManager creates an ActionTokenManager signing with
+// HMAC-SHA256 and secret, which must be at least 32 bytes long.
+func NewHMACActionTokenManager(secret string, opts ...ActionTokenOption) (*ActionTokenManager, error) {
+	ring, err := newKeyRing(KeyRingConfig{
+		Keys: []KeyConfig{{Alg: jwt.SigningMethodHS256.Alg(), Secret: secret}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newActionTokenManager(func() (*keyRing, error) { return ring, nil }, opts), nil
+}
+
+func newActionTokenManager(keys func() (*keyRing, error), opts []ActionTokenOption) *ActionTokenManager {
+	m := &ActionTokenManager{keys: keys}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Issue issues a token for purpose and sub, valid for ttl.
+func (m *ActionTokenManager) Issue(ctx context.Context, purpose, sub string, ttl time.Duration, data map[string]string) (string, error) {
+	if purpose == "" {
+		return "", kiterrors.WithStack(ErrActionPurposeEmpty)
+	}
+
+	ring, err := m.keys()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ring.signing()
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	stamp, err := m.passwordStamp(ctx, sub)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	c := ActionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   sub,
+			Audience:  jwt.ClaimStrings{actionTokenAudience},
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Purpose:       purpose,
+		Data:          data,
+		PasswordStamp: stamp,
+	}
+
+	t := jwt.NewWithClaims(key.method, c)
+	t.Header["typ"] = actionTokenType
+	if key.kid != "" {
+		t.Header["kid"] = key.kid
+	}
+
+	token, err := t.SignedString(key.signKey)
+	if err != nil {
+		return "", kiterrors.WithStack(err)
+	}
+
+	return token, nil
+}
+
+// Verify checks token is a valid token for purpose without consuming it, for
+// instance to render a password reset form.
+func (m *ActionTokenManager) Verify(ctx context.Context, token, purpose string) (*ActionClaims, error) {
+	var c ActionClaims
+
+	ring, err := m.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = jwt.ParseWithClaims(token, &c, func(t *jwt.Token) (interface{}, error) {
+		if !isActionToken(t) {
+			return nil, fmt.Errorf("unexpected token type: %v", t.Header["typ"])
+		}
+
+		kid, _ := t.Header["kid"].(string)
+
+		key, err := ring.verifying(kid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+
+		return key.verifyKey, nil
+	}, jwt.WithAudience(actionTokenAudience))
+	if err != nil {
+		return nil, jwtError(err)
+	}
+
+	if c.ExpiresAt == nil {
+		return nil, kiterrors.ErrBearerTokenInvalid.WithDetails("token has no expiry")
+	}
+
+	if c.Purpose != purpose {
+		return nil, kiterrors.ErrBearerTokenInvalid.WithDetails("token purpose mismatch")
+	}
+
+	if m.passwordChanged != nil {
+		stamp, err := m.passwordStamp(ctx, c.Subject)
+		if err != nil {
+			return nil, err
+		}
+
+		if stamp != c.PasswordStamp {
+			return nil, kiterrors.ErrTokenBlacklisted.WithDetails("password changed since the token was issued")
+		}
+	}
+
+	return &c, nil
+}
+
+// Consume verifies token for purpose and, with a ConsumedTokenStore, consumes
+// it so that it can't be used again.
+func (m *ActionTokenManager) Consume(ctx context.Context, token, purpose string) (*ActionClaims, error) {
+	c, err := m.Verify(ctx, token, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.consumed != nil {
+		ok, err := m.consumed.Consume(ctx, c.ID, c.ExpiresAt.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, kiterrors.ErrTokenBlacklisted.WithDetails("token already used")
+		}
+	}
+
+	return c, nil
+}
+
+// passwordStamp fingerprints the password change time of sub, so that it
+// does not appear in the token.
+func (m *ActionTokenManager) passwordStamp(ctx context.Context, sub string) (string, error) {
+	if m.passwordChanged == nil {
+		return "", nil
+	}
+
+	changedAt, err := m.passwordChanged(ctx, sub)
+	if err != nil {
+		return "", err
+	}
+
+	var nanos int64
+	if !changedAt.IsZero() {
+		nanos = changedAt.UnixNano()
+	}
+
+	sum := sha256.Sum256([]byte(sub + ":" + strconv.FormatInt(nanos, 10)))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+// isActionToken reports whether t is an action token.
+func isActionToken(t *jwt.Token) bool {
+	typ, _ := t.Header["typ"].(string)
+	return typ == actionTokenType
+}
+
+// checkNotActionToken rejects the claims of an action token parsed as an
+// access token.
+func checkNotActionToken(c *Claims) error {
+	for _, aud := range c.Audience {
+		if aud == actionTokenAudience {
+			return fmt.Errorf("%w: %w", jwt.ErrTokenInvalidClaims, ErrActionTokenType)
+		}
+	}
+
+	return nil
+}
+
+// memoryConsumedTokenStore is an in-memory ConsumedTokenStore.
+type memoryConsumedTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	sweeps sweep.Schedule
+}
+
+// NewMemoryConsumedTokenStore creates a ConsumedTokenStore kept in memory,
+// suitable for tests and single instance services.
+func NewMemoryConsumedTokenStore() ConsumedTokenStore {
+	return &memoryConsumedTokenStore{tokens: map[string]time.Time{}}
+}
+
+// Consume implements ConsumedTokenStore.
+func (s *memoryConsumedTokenStore) Consume(_ context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.sweeps.Due(now) {
+		for id, exp := range s.tokens {
+			if now.After(exp) {
+				delete(s.tokens, id)
+			}
+		}
+	}
+
+	if exp, ok := s.tokens[jti]; ok && !now.After(exp) {
+		return false, nil
+	}
+
+	s.tokens[jti] = expiresAt
+
+	return true, nil
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/quocdaitrn/golang-kit/constant"
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+const (
+	apiKeyAlphabet       = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	apiKeySecretLength   = 30
+	apiKeyChecksumLength = 6
+)
+
+var ErrAPIKeyPrefixInvalid = kiterrors.New("API key prefix must be lowercase letters and digits")
+
+// APIKey is the stored state of an API key. Only the hash of the key is
+// stored, Hint keeps its last characters to help users tell keys apart.
+type APIKey struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Prefix string `json:"prefix"`
+	Hash   string `json:"hash"`
+	Hint   string `json:"hint"`
+
+	// Identity of the consumer owning the key.
+	Sub    string   `json:"sub"`
+	Tid    string   `json:"tid,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	// ExpiresAt is the expiry of the key, the zero time if it never expires.
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// APIKeyStore persists API keys.
+type APIKeyStore interface {
+	// Create stores a new key.
+	Create(ctx context.Context, k *APIKey) error
+
+	// GetByHash returns the key with hash, or ErrRepoEntityNotFound.
+	GetByHash(ctx context.Context, hash string) (*APIKey, error)
+
+	// Revoke revokes the key with id.
+	Revoke(ctx context.Context, id string) error
+}
+
+// APIKeyManager issues and checks API keys of the form
+// <prefix>_<30 random characters><6 characters checksum>. The visible prefix
+// identifies the issuer, as in secret scanners, and the checksum rejects
+// mistyped or made up keys without a store lookup.
+type APIKeyManager struct {
+	prefix string
+	store  APIKeyStore
+}
+
+// NewAPIKeyManager creates an APIKeyManager issuing keys with prefix and
+// storing them in s.
+func NewAPIKeyManager(prefix string, s APIKeyStore) (*APIKeyManager, error) {
+	if prefix == "" || strings.IndexFunc(prefix, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		return nil, kiterrors.WithStack(ErrAPIKeyPrefixInvalid)
+	}
+
+	return &APIKeyManager{prefix: prefix, store: s}, nil
+}
+
+// Create generates a key for k, which holds the identity, scopes and expiry
+// of the key, and stores it. The returned key must be shown once to the
+// consumer, it can't be recovered.
+func (m *APIKeyManager) Create(ctx context.Context, k *APIKey) (key string, err error) {
+	secret, err := randomAlphanumeric(apiKeySecretLength)
+	if err != nil {
+		return "", err
+	}
+
+	body := m.prefix + "_" + secret
+	key = body + apiKeyChecksum(body)
+
+	if k.ID == "" {
+		if k.ID, err = randomString(12); err != nil {
+			return "", err
+		}
+	}
+	k.Prefix = m.prefix
+	k.Hash = hashAPIKey(key)
+	k.Hint = key[len(key)-4:]
+	k.CreatedAt = time.Now().UTC()
+	k.Revoked = false
+
+	if err := m.store.Create(ctx, k); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Lookup returns the stored state of a valid key. Malformed, revoked and
+// expired keys yield ErrNoCredentialsMatch and unknown keys
+// ErrConsumerNotFound.
+func (m *APIKeyManager) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	if !m.wellFormed(key) {
+		return nil, kiterrors.ErrNoCredentialsMatch.WithDetails("malformed API key")
+	}
+
+	k, err := m.store.GetByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		if kiterrors.ErrRepoEntityNotFound.Equal(err) {
+			return nil, kiterrors.ErrConsumerNotFound.WithDetails("unknown API key")
+		}
+		return nil, err
+	}
+
+	if k.Revoked {
+		return nil, kiterrors.ErrNoCredentialsMatch.WithDetails("API key revoked")
+	}
+
+	if !k.ExpiresAt.IsZero() && !time.Now().Before(k.ExpiresAt) {
+		return nil, kiterrors.ErrNoCredentialsMatch.WithDetails("API key expired")
+	}
+
+	return k, nil
+}
+
+// Revoke revokes the key with id.
+func (m *APIKeyManager) Revoke(ctx context.Context, id string) error {
+	return m.store.Revoke(ctx, id)
+}
+
+// wellFormed checks the prefix, length and checksum of key.
+func (m *APIKeyManager) wellFormed(key string) bool {
+	if !strings.HasPrefix(key, m.prefix+"_") {
+		return false
+	}
+
+	if len(key) != len(m.prefix)+1+apiKeySecretLength+apiKeyChecksumLength {
+		return false
+	}
+
+	body, sum := key[:len(key)-apiKeyChecksumLength], key[len(key)-apiKeyChecksumLength:]
+
+	return apiKeyChecksum(body) == sum
+}
+
+// AuthenticateAPIKey returns a middleware authenticating the API key placed in
+// the context by http.PopulateRequestAPIKey. The identity, roles and scopes
+// of the key are added to the context like Authenticate does.
+func AuthenticateAPIKey(m *APIKeyManager) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			key := strings.TrimSpace(constant.ContextAPIKey.Get(ctx))
+			if key == "" {
+				return nil, kiterrors.ErrUnauthorized.WithDetails("missing API key")
+			}
+
+			k, err := m.Lookup(ctx, key)
+			if err != nil {
+				return nil, authError(err)
+			}
+
+			ctx = kitcontext.WithPrincipal(ctx, kitcontext.Principal{
+				Sub:        k.Sub,
+				Tid:        k.Tid,
+				Roles:      k.Roles,
+				Scopes:     k.Scopes,
+				AuthMethod: kitcontext.AuthMethodAPIKey,
+				TokenID:    k.ID,
+				AuthTime:   time.Now(),
+			})
+			return next(ctx, request)
+		}
+	}
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyChecksum encodes the CRC32 of body in base62.
+func apiKeyChecksum(body string) string {
+	n := crc32.ChecksumIEEE([]byte(body))
+
+	b := make([]byte, apiKeyChecksumLength)
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = apiKeyAlphabet[n%62]
+		n /= 62
+	}
+
+	return string(b)
+}
+
+// randomAlphanumeric returns n random base62 characters.
+func randomAlphanumeric(n int) (string, error) {
+	max := big.NewInt(int64(len(apiKeyAlphabet)))
+
+	b := make([]byte, n)
+	for i := range b {
+		v, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", kiterrors.WithStack(err)
+		}
+		b[i] = apiKeyAlphabet[v.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// memoryAPIKeyStore is an in-memory APIKeyStore, suitable for tests and
+// single instance services.
+type memoryAPIKeyStore struct {
+	mu     sync.RWMutex
+	keys   map[string]APIKey
+	hashes map[string]string
+}
+
+// NewMemoryAPIKeyStore creates an APIKeyStore kept in memory.
+func NewMemoryAPIKeyStore() APIKeyStore {
+	return &memoryAPIKeyStore{
+		keys:   map[string]APIKey{},
+		hashes: map[string]string{},
+	}
+}
+
+// Create implements APIKeyStore.
+func (s *memoryAPIKeyStore) Create(_ context.Context, k *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[k.ID]; ok {
+		return kiterrors.WithStack(kiterrors.ErrRepoDuplicateKey)
+	}
+
+	s.keys[k.ID] = *k
+	s.hashes[k.Hash] = k.ID
+
+	return nil
+}
+
+// GetByHash implements APIKeyStore.
+func (s *memoryAPIKeyStore) GetByHash(_ context.Context, hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k, ok := s.keys[s.hashes[hash]]
+	if !ok {
+		return nil, kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	return &k, nil
+}
+
+// Revoke implements APIKeyStore.
+func (s *memoryAPIKeyStore) Revoke(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[id]
+	if !ok {
+		return kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	k.Revoked = true
+	s.keys[id] = k
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/quocdaitrn/golang-kit/constant"
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// BasicUser is a user of Basic and Digest authentication, such as an operator
+// of internal tooling. The password is never stored: PasswordHash is its hash
+// by Hasher.HashPassword with Salt, used by Basic, and DigestHA1 is the
+// DigestHA1 of the user, used by Digest.
+type BasicUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+	Salt         string `json:"salt,omitempty"`
+	DigestHA1    string `json:"digestHa1,omitempty"`
+
+	// Identity of the user, Sub defaulting to the username.
+	Sub    string   `json:"sub,omitempty"`
+	Tid    string   `json:"tid,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// principal returns the principal of u authenticated with method.
+func (u *BasicUser) principal(method string) kitcontext.Principal {
+	sub := u.Sub
+	if sub == "" {
+		sub = u.Username
+	}
+
+	return kitcontext.Principal{
+		Sub:        sub,
+		Tid:        u.Tid,
+		Roles:      u.Roles,
+		Scopes:     u.Scopes,
+		AuthMethod: method,
+		AuthTime:   time.Now(),
+	}
+}
+
+// BasicUserStore looks up the users of Basic and Digest authentication.
+type BasicUserStore interface {
+	// GetByUsername returns the user with username, or ErrRepoEntityNotFound.
+	GetByUsername(ctx context.Context, username string) (*BasicUser, error)
+}
+
+// BasicAuthenticator authenticates users with HTTP Basic authentication, as
+// described in RFC 7617. Credentials are sent in clear text, it must only be
+// used over TLS.
+type BasicAuthenticator struct {
+	realm  string
+	users  BasicUserStore
+	hasher *Hasher
+
+	// dummyHash is verified for unknown users, so that they take as long to
+	// reject as wrong passwords.
+	dummyHash string
+	dummySalt string
+}
+
+// NewBasicAuthenticator creates a BasicAuthenticator of realm verifying the
+// passwords of users with h.
+func NewBasicAuthenticator(realm string, users BasicUserStore, h *Hasher) (*BasicAuthenticator, error) {
+	salt, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	dummyHash, err := h.HashPassword(salt, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BasicAuthenticator{
+		realm:     realm,
+		users:     users,
+		hasher:    h,
+		dummyHash: dummyHash,
+		dummySalt: salt,
+	}, nil
+}
+
+// Challenge returns the "WWW-Authenticate" challenge of the authenticator.
+func (a *BasicAuthenticator) Challenge() string {
+	return `Basic realm=` + quoteParam(a.realm) + `, charset="UTF-8"`
+}
+
+// Authenticate returns the principal of username when password is valid, or
+// fails with ErrNoCredentialsMatch.
+func (a *BasicAuthenticator) Authenticate(ctx context.Context, username, password string) (kitcontext.Principal, error) {
+	u, err := a.users.GetByUsername(ctx, username)
+	if err != nil && !kiterrors.ErrRepoEntityNotFound.Equal(err) {
+		return kitcontext.Principal{}, err
+	}
+
+	if u == nil || u.PasswordHash == "" {
+		_, _ = a.hasher.Verify(a.dummyHash, a.dummySalt, password)
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("invalid username or password")
+	}
+
+	res, err := a.hasher.Verify(u.PasswordHash, u.Salt, password)
+	if err != nil {
+		return kitcontext.Principal{}, err
+	}
+
+	if !res.Valid {
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("invalid username or password")
+	}
+
+	return u.principal(kitcontext.AuthMethodBasic), nil
+}
+
+// AuthenticateBasic returns a middleware authenticating the Basic credentials
+// of the "Authorization" header, populated by
+// http.PopulateRequestAuthorizationToken or http.PopulateRequestCredentials.
+// The principal is added to the context like Authenticate does. Failures
+// carry the challenge of a, sent by http.DefaultErrorEncoder.
+func AuthenticateBasic(a *BasicAuthenticator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			params, ok := authorizationParams(ctx, "Basic")
+			if !ok {
+				return nil, withChallenges(kiterrors.ErrAuthorizationHeaderMissing.WithDetails("missing Basic credentials"), a.Challenge())
+			}
+
+			raw, err := base64.StdEncoding.DecodeString(params)
+			if err != nil {
+				return nil, withChallenges(kiterrors.ErrNoCredentialsMatch.WithDetails("malformed Basic credentials"), a.Challenge())
+			}
+
+			username, password, ok := strings.Cut(string(raw), ":")
+			if !ok {
+				return nil, withChallenges(kiterrors.ErrNoCredentialsMatch.WithDetails("malformed Basic credentials"), a.Challenge())
+			}
+
+			p, err := a.Authenticate(ctx, username, password)
+			if err != nil {
+				return nil, withChallenges(err, a.Challenge())
+			}
+
+			ctx = kitcontext.WithPrincipal(ctx, p)
+			return next(ctx, request)
+		}
+	}
+}
+
+// authorizationParams returns the parameters of the "Authorization" header of
+// scheme, as populated by http.PopulateRequestCredentials or
+// http.PopulateRequestAuthorizationToken.
+func authorizationParams(ctx context.Context, scheme string) (string, bool) {
+	h := constant.ContextAuthorization.Get(ctx)
+	for _, c := range kitcontext.CredentialsFromContext(ctx) {
+		if isAuthorizationHeader(c) {
+			h = c.Value
+			break
+		}
+	}
+
+	s, params, ok := strings.Cut(strings.TrimSpace(h), " ")
+	if !ok || !strings.EqualFold(s, scheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(params), true
+}
+
+// challengeError is an authentication failure carrying the "WWW-Authenticate"
+// challenges the client must answer.
+type challengeError struct {
+	err        error
+	challenges []string
+}
+
+func withChallenges(err error, challenges ...string) error {
+	return &challengeError{err: err, challenges: challenges}
+}
+
+func (e *challengeError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the underlying error, which the HTTP error encoder maps.
+func (e *challengeError) Cause() error {
+	return e.err
+}
+
+func (e *challengeError) Unwrap() error {
+	return e.err
+}
+
+// Challenges returns the "WWW-Authenticate" challenges.
+func (e *challengeError) Challenges() []string {
+	return e.challenges
+}
+
+// quoteParam quotes an authentication parameter value.
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// memoryBasicUserStore is an in-memory BasicUserStore, suitable for users
+// loaded from configuration.
+type memoryBasicUserStore struct {
+	users map[string]BasicUser
+}
+
+// NewMemoryBasicUserStore creates an in-memory BasicUserStore with users.
+func NewMemoryBasicUserStore(users ...BasicUser) BasicUserStore {
+	s := &memoryBasicUserStore{users: make(map[string]BasicUser, len(users))}
+	for _, u := range users {
+		s.users[u.Username] = u
+	}
+
+	return s
+}
+
+func (s *memoryBasicUserStore) GetByUsername(_ context.Context, username string) (*BasicUser, error) {
+	u, ok := s.users[username]
+	if !ok {
+		return nil, kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	return &u, nil
+}
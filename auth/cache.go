@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// lruCache is a size bounded cache evicting the least recently used entry,
+// whose entries also expire after their own TTL.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// newLRUCache creates a cache holding at most size entries.
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		ll:      list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get returns the value of key if it is cached and not expired.
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*lruEntry)
+	if !time.Now().Before(e.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+
+	return e.value, true
+}
+
+// set caches value for ttl. A non positive ttl removes the key.
+func (c *lruCache) set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+	}
+
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	c.entries[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)})
+
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// remove deletes key from the cache.
+func (c *lruCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
+
+// callGroup coalesces concurrent calls with the same key into one execution.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*groupCall
+}
+
+type groupCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// do executes fn once for all concurrent callers of key and hands every
+// caller the same result. fn runs detached from the cancellation of the
+// caller which started it, bounded by timeout, so that one caller giving up
+// doesn't fail the others. Each caller stops waiting when its own ctx is
+// done.
+func (g *callGroup) do(ctx context.Context, key string, timeout time.Duration, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*groupCall{}
+	}
+
+	c, ok := g.calls[key]
+	if !ok {
+		c = &groupCall{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(detachedContext{ctx}, key, timeout, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return nil, kiterrors.WithStack(ctx.Err())
+	}
+}
+
+func (g *callGroup) run(ctx context.Context, key string, timeout time.Duration, c *groupCall, fn func(ctx context.Context) (interface{}, error)) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c.val, c.err = fn(ctx)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	close(c.done)
+}
+
+// detachedContext carries the values of its parent but none of its
+// cancellation, like context.WithoutCancel of Go 1.21.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
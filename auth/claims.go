@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	"github.com/quocdaitrn/golang-kit/errors"
+)
+
+// Claims are the claims of tokens issued by the kit. Besides the registered
+// claims they carry the tenant of the subject, its roles and scopes and any
+// extra application specific values.
+type Claims struct {
+	jwt.RegisteredClaims
+
+	// Tid is the tenant id of the subject.
+	Tid string `json:"tid,omitempty"`
+
+	// Roles are the roles granted to the subject.
+	Roles []string `json:"roles,omitempty"`
+
+	// Scopes are the scopes granted to the token, encoded as the space
+	// delimited "scope" claim.
+	Scopes SpaceDelimited `json:"scope,omitempty"`
+
+	// Act is the party acting on behalf of the subject, set on
+	// impersonation tokens.
+	Act *Actor `json:"act,omitempty"`
+
+	// Extra holds arbitrary application claims.
+	Extra map[string]interface{} `json:"ext,omitempty"`
+}
+
+// Principal converts the claims of a bearer token to the principal of the
+// context. The extra claims are copied, so that the principal doesn't share
+// them with the claims.
+func (c *Claims) Principal() kitcontext.Principal {
+	var attrs map[string]interface{}
+	if len(c.Extra) > 0 {
+		attrs = make(map[string]interface{}, len(c.Extra))
+		for k, v := range c.Extra {
+			attrs[k] = v
+		}
+	}
+
+	p := kitcontext.Principal{
+		Sub:        c.Subject,
+		Tid:        c.Tid,
+		Act:        c.Act.UID(),
+		Roles:      c.Roles,
+		Scopes:     c.Scopes,
+		AuthMethod: kitcontext.AuthMethodBearer,
+		TokenID:    c.ID,
+		Attributes: attrs,
+	}
+
+	if c.IssuedAt != nil {
+		p.AuthTime = c.IssuedAt.Time
+	}
+
+	return p
+}
+
+// Actor is the "act" claim of RFC 8693, identifying the party acting on
+// behalf of the subject. A nested Act is the previous actor of a delegation
+// chain.
+type Actor struct {
+	Sub string `json:"sub"`
+	Tid string `json:"tid,omitempty"`
+	Act *Actor `json:"act,omitempty"`
+}
+
+// UID converts the actor to the identity of the context.
+func (a *Actor) UID() *kitcontext.UID {
+	if a == nil {
+		return nil
+	}
+
+	return &kitcontext.UID{Sub: a.Sub, Tid: a.Tid, Act: a.Act.UID()}
+}
+
+// SpaceDelimited is a list of strings encoded as a space delimited string,
+// which is how OAuth 2.0 represents scopes. It also decodes JSON arrays.
+type SpaceDelimited []string
+
+// MarshalJSON implements json.Marshaler.
+func (s SpaceDelimited) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.Join(s, " "))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *SpaceDelimited) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		*s = strings.Fields(str)
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return errors.WithStack(err)
+	}
+	*s = list
+
+	return nil
+}
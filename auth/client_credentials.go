@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+const (
+	defaultTokenRefreshBefore = time.Minute
+	defaultTokenFetchTimeout  = 10 * time.Second
+
+	// Bounds of the delay between failed background refreshes.
+	tokenRefreshMinBackoff = time.Second
+	tokenRefreshMaxBackoff = 30 * time.Second
+)
+
+// ClientCredentialsOption configures a client credentials token source.
+type ClientCredentialsOption func(*clientCredentialsSource)
+
+// WithTokenScopes sets the scopes requested for the token.
+func WithTokenScopes(scopes ...string) ClientCredentialsOption {
+	return func(s *clientCredentialsSource) {
+		s.scopes = scopes
+	}
+}
+
+// WithTokenAudience sets the audience parameter of the token request, for
+// authorization servers issuing tokens per audience.
+func WithTokenAudience(aud string) ClientCredentialsOption {
+	return func(s *clientCredentialsSource) {
+		s.audience = aud
+	}
+}
+
+// WithTokenHTTPClient sets the client used to call the token endpoint.
+func WithTokenHTTPClient(c *http.Client) ClientCredentialsOption {
+	return func(s *clientCredentialsSource) {
+		s.client = c
+	}
+}
+
+// WithTokenRefreshBefore sets how long before its expiry a token is refreshed
+// in the background, one minute by default.
+func WithTokenRefreshBefore(d time.Duration) ClientCredentialsOption {
+	return func(s *clientCredentialsSource) {
+		s.refreshBefore = d
+	}
+}
+
+// clientCredentialsSource obtains access tokens for the service itself with
+// the OAuth 2.0 client credentials grant.
+type clientCredentialsSource struct {
+	tokenURL      string
+	clientID      string
+	clientSecret  string
+	scopes        []string
+	audience      string
+	client        *http.Client
+	refreshBefore time.Duration
+
+	mu         sync.Mutex
+	token      string
+	expiresAt  time.Time
+	refreshAt  time.Time
+	refreshing bool
+	backoff    time.Duration
+
+	group callGroup
+}
+
+// NewClientCredentialsTokenSource creates a token source fetching tokens from
+// tokenURL with the client credentials. Tokens are cached, refreshed in the
+// background shortly before they expire, and concurrent fetches share one
+// request. It is meant to be used with http.SetRequestAuthorizationToken.
+func NewClientCredentialsTokenSource(tokenURL, clientID, clientSecret string, opts ...ClientCredentialsOption) *clientCredentialsSource {
+	s := &clientCredentialsSource{
+		tokenURL:      tokenURL,
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		client:        http.DefaultClient,
+		refreshBefore: defaultTokenRefreshBefore,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Token returns a valid access token.
+func (s *clientCredentialsSource) Token(ctx context.Context) (string, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	token, expiresAt := s.token, s.expiresAt
+	refresh := token != "" && now.Before(expiresAt) && !now.Before(s.refreshAt) && !s.refreshing
+	if refresh {
+		s.refreshing = true
+	}
+	s.mu.Unlock()
+
+	if token != "" && now.Before(expiresAt) {
+		if refresh {
+			go func() {
+				_, _ = s.fetch(context.Background())
+			}()
+		}
+		return token, nil
+	}
+
+	return s.fetch(ctx)
+}
+
+// fetch requests a new token, sharing the request with concurrent callers.
+func (s *clientCredentialsSource) fetch(ctx context.Context) (string, error) {
+	v, err := s.group.do(ctx, "token", defaultTokenFetchTimeout, func(ctx context.Context) (interface{}, error) {
+		defer func() {
+			s.mu.Lock()
+			s.refreshing = false
+			s.mu.Unlock()
+		}()
+
+		token, expiresIn, err := s.call(ctx)
+		if err != nil {
+			s.retryLater()
+			return nil, err
+		}
+
+		// Short lived tokens are refreshed at half their lifetime.
+		refreshBefore := s.refreshBefore
+		if refreshBefore > expiresIn/2 {
+			refreshBefore = expiresIn / 2
+		}
+
+		now := time.Now()
+
+		s.mu.Lock()
+		s.token = token
+		s.expiresAt = now.Add(expiresIn)
+		s.refreshAt = s.expiresAt.Add(-refreshBefore)
+		s.backoff = 0
+		s.mu.Unlock()
+
+		return token, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return v.(string), nil
+}
+
+// retryLater postpones the next background refresh after a failure, with an
+// exponential backoff, so that a failing token endpoint isn't called by every
+// request while the cached token is still valid.
+func (s *clientCredentialsSource) retryLater() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backoff *= 2
+	if s.backoff < tokenRefreshMinBackoff {
+		s.backoff = tokenRefreshMinBackoff
+	}
+	if s.backoff > tokenRefreshMaxBackoff {
+		s.backoff = tokenRefreshMaxBackoff
+	}
+
+	s.refreshAt = time.Now().Add(s.backoff)
+	if s.refreshAt.After(s.expiresAt) {
+		s.refreshAt = s.expiresAt
+	}
+}
+
+// tokenResponse is the response of an OAuth 2.0 token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// call requests the token endpoint.
+func (s *clientCredentialsSource) call(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	if s.audience != "" {
+		form.Set("audience", s.audience)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, kiterrors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, kiterrors.ErrServiceUnavailable.WithDetails(err)
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBodySize)).Decode(&tr); err != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, kiterrors.ErrBadGateway.WithDetails(err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized:
+		return "", 0, kiterrors.ErrUnauthorized.WithDetails(kiterrors.Errorf("token endpoint: %s %s", tr.Error, tr.ErrorDescription))
+	case resp.StatusCode != http.StatusOK:
+		return "", 0, kiterrors.ErrBadGateway.WithDetails(kiterrors.Errorf("token endpoint responded with status %d", resp.StatusCode))
+	case tr.AccessToken == "":
+		return "", 0, kiterrors.ErrBadGateway.WithDetails("token endpoint returned no access token")
+	case tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "Bearer"):
+		return "", 0, kiterrors.ErrBadGateway.WithDetails(kiterrors.Errorf("unsupported token type %q", tr.TokenType))
+	}
+
+	expiresIn := time.Duration(tr.ExpiresIn) * time.Second
+	if tr.ExpiresIn <= 0 {
+		expiresIn = s.refreshBefore + time.Minute
+	}
+
+	return tr.AccessToken, expiresIn, nil
+}
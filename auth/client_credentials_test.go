@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	kithttp "github.com/quocdaitrn/golang-kit/http"
+)
+
+// tokenServer is a token endpoint issuing numbered tokens valid for
+// expiresIn seconds. Requests block while gate is set.
+type tokenServer struct {
+	*httptest.Server
+
+	expiresIn int
+	gate      chan struct{}
+	fail      int32
+	calls     int32
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	t.Helper()
+
+	s := &tokenServer{expiresIn: expiresIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&s.calls, 1)
+
+		if s.gate != nil {
+			<-s.gate
+		}
+
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" || atomic.LoadInt32(&s.fail) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-" + strconv.Itoa(int(n)),
+			"token_type":   "Bearer",
+			"expires_in":   s.expiresIn,
+		})
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestClientCredentialsCachesToken(t *testing.T) {
+	srv := newTokenServer(t, 3600)
+	ts := NewClientCredentialsTokenSource(srv.URL, "client", "secret", WithTokenScopes("read", "write"))
+
+	for i := 0; i < 3; i++ {
+		token, err := ts.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Fatalf("token = %q, want token-1", token)
+		}
+	}
+
+	if n := atomic.LoadInt32(&srv.calls); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+func TestClientCredentialsRefreshesBeforeExpiry(t *testing.T) {
+	// Tokens of 2 seconds are refreshed after 1 second, half their lifetime.
+	srv := newTokenServer(t, 2)
+	ts := NewClientCredentialsTokenSource(srv.URL, "client", "secret", WithTokenScopes("read", "write"))
+
+	ctx := context.Background()
+	if token, err := ts.Token(ctx); err != nil || token != "token-1" {
+		t.Fatalf("token = %q, %v", token, err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	// The current token is still served while it is refreshed.
+	if token, err := ts.Token(ctx); err != nil || token != "token-1" {
+		t.Fatalf("token during refresh = %q, %v", token, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		token, err := ts.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token == "token-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token = %q, not refreshed before expiry", token)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&srv.calls); n != 2 {
+		t.Errorf("calls = %d, want 2", n)
+	}
+}
+
+func TestClientCredentialsCoalescesFetches(t *testing.T) {
+	srv := newTokenServer(t, 3600)
+	srv.gate = make(chan struct{})
+	ts := NewClientCredentialsTokenSource(srv.URL, "client", "secret", WithTokenScopes("read", "write"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ts.Token(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(srv.gate)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&srv.calls); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+func TestClientCredentialsBacksOffFailedRefreshes(t *testing.T) {
+	srv := newTokenServer(t, 2)
+	ts := NewClientCredentialsTokenSource(srv.URL, "client", "secret", WithTokenScopes("read", "write"))
+
+	ctx := context.Background()
+	if _, err := ts.Token(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&srv.fail, 1)
+	time.Sleep(1100 * time.Millisecond)
+
+	// The cached token is served while the refresh fails, which is retried
+	// after the backoff only.
+	for i := 0; i < 30; i++ {
+		if token, err := ts.Token(ctx); err != nil || token != "token-1" {
+			t.Fatalf("token = %q, %v", token, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&srv.calls); n != 2 {
+		t.Errorf("calls = %d, want 2 during the backoff", n)
+	}
+}
+
+func TestSetRequestAuthorizationTokenBacksOffAfterFailure(t *testing.T) {
+	srv := newTokenServer(t, 3600)
+	atomic.StoreInt32(&srv.fail, 1)
+
+	ts := NewClientCredentialsTokenSource(srv.URL, "client", "secret", WithTokenScopes("read", "write"))
+	before := kithttp.SetRequestAuthorizationToken(ts)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://api.test/", nil)
+		ctx := before(context.Background(), r)
+
+		if err := kithttp.ClientRequestError(ctx); !kiterrors.ErrUnauthorized.Equal(err) {
+			t.Fatalf("request error = %v, want ErrUnauthorized", err)
+		}
+		if ctx.Err() == nil {
+			t.Fatal("request not aborted")
+		}
+		if h := r.Header.Get("Authorization"); h != "" {
+			t.Fatalf("Authorization = %q", h)
+		}
+	}
+
+	if n := atomic.LoadInt32(&srv.calls); n != 1 {
+		t.Errorf("calls = %d, want 1 during the backoff", n)
+	}
+
+	atomic.StoreInt32(&srv.fail, 0)
+	time.Sleep(1100 * time.Millisecond)
+
+	r := httptest.NewRequest(http.MethodGet, "http://api.test/", nil)
+	ctx := before(context.Background(), r)
+	if err := kithttp.ClientRequestError(ctx); err != nil {
+		t.Fatalf("request error after backoff = %v", err)
+	}
+	if h := r.Header.Get("Authorization"); h != "Bearer token-2" {
+		t.Errorf("Authorization = %q, want Bearer token-2", h)
+	}
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+const defaultDigestNonceTTL = 5 * time.Minute
+
+// DigestHA1 returns the SHA-256 digest of the credentials of username in
+// realm, stored as BasicUser.DigestHA1 instead of the password.
+func DigestHA1(username, realm, password string) string {
+	return sha256Hex(username + ":" + realm + ":" + password)
+}
+
+// DigestOption configures a DigestAuthenticator.
+type DigestOption func(*DigestAuthenticator)
+
+// WithDigestNonceTTL sets how long a nonce is accepted, 5 minutes by default.
+// Clients retry expired nonces transparently.
+func WithDigestNonceTTL(d time.Duration) DigestOption {
+	return func(a *DigestAuthenticator) {
+		a.nonceTTL = d
+	}
+}
+
+// WithDigestReplayStore sets the store of the nonce counts already used, so
+// that replayed requests are rejected. An in-memory store is used by default,
+// which is only suitable for single instance services.
+func WithDigestReplayStore(s ConsumedTokenStore) DigestOption {
+	return func(a *DigestAuthenticator) {
+		a.replays = s
+	}
+}
+
+// DigestAuthenticator authenticates users with HTTP Digest authentication, as
+// described in RFC 7616, with the SHA-256 algorithm and the "auth" quality of
+// protection. Nonces are stateless, signed with a secret, and credentials
+// without an algorithm are taken as SHA-256 ones. Each nonce count of a
+// nonce is accepted once.
+type DigestAuthenticator struct {
+	realm    string
+	users    BasicUserStore
+	secret   []byte
+	nonceTTL time.Duration
+	replays  ConsumedTokenStore
+}
+
+// NewDigestAuthenticator creates a DigestAuthenticator of realm signing nonces
+// with secret.
+func NewDigestAuthenticator(realm string, users BasicUserStore, secret []byte, opts ...DigestOption) *DigestAuthenticator {
+	a := &DigestAuthenticator{
+		realm:    realm,
+		users:    users,
+		secret:   secret,
+		nonceTTL: defaultDigestNonceTTL,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.replays == nil {
+		a.replays = NewMemoryConsumedTokenStore()
+	}
+
+	return a
+}
+
+// Challenge returns a "WWW-Authenticate" challenge with a new nonce, stale
+// telling the client that only its nonce expired.
+func (a *DigestAuthenticator) Challenge(stale bool) (string, error) {
+	nonce, err := a.newNonce(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	c := `Digest realm=` + quoteParam(a.realm) + `, qop="auth", algorithm=SHA-256, nonce=` + quoteParam(nonce)
+	if stale {
+		c += `, stale=true`
+	}
+
+	return c, nil
+}
+
+// Authenticate verifies the parameters of a Digest "Authorization" header for
+// a request of method to uri and returns the principal of the user.
+// Invalid credentials fail with ErrNoCredentialsMatch and expired nonces with
+// ErrTokenExpired.
+func (a *DigestAuthenticator) Authenticate(ctx context.Context, method, uri, params string) (kitcontext.Principal, error) {
+	p, ok := parseAuthParams(params)
+	if !ok {
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("malformed Digest credentials")
+	}
+
+	for _, name := range []string{"username", "realm", "nonce", "uri", "response", "qop", "nc", "cnonce"} {
+		if p[name] == "" {
+			return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("missing Digest parameter " + name)
+		}
+	}
+
+	switch {
+	case p["realm"] != a.realm:
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("unknown realm")
+	case p["algorithm"] != "" && !strings.EqualFold(p["algorithm"], "SHA-256"):
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("unsupported Digest algorithm")
+	case p["qop"] != "auth":
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("unsupported quality of protection")
+	case p["uri"] != uri:
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("Digest uri doesn't match the request")
+	}
+
+	issuedAt, ok := a.verifyNonce(p["nonce"])
+	if !ok {
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("invalid nonce")
+	}
+
+	expiresAt := issuedAt.Add(a.nonceTTL)
+	if !time.Now().Before(expiresAt) {
+		return kitcontext.Principal{}, kiterrors.ErrTokenExpired.WithDetails("nonce expired")
+	}
+
+	u, err := a.users.GetByUsername(ctx, p["username"])
+	if err != nil && !kiterrors.ErrRepoEntityNotFound.Equal(err) {
+		return kitcontext.Principal{}, err
+	}
+
+	// Unknown users are checked against a random digest, so that they take
+	// as long to reject as wrong passwords.
+	ha1 := sha256Hex(p["nonce"])
+	if u != nil && u.DigestHA1 != "" {
+		ha1 = u.DigestHA1
+	}
+
+	ha2 := sha256Hex(method + ":" + p["uri"])
+	expected := sha256Hex(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":" + p["qop"] + ":" + ha2)
+
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(p["response"])), []byte(expected)) != 1 || u == nil || u.DigestHA1 == "" {
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("invalid username or password")
+	}
+
+	ok, err = a.replays.Consume(ctx, "digest:"+p["nonce"]+":"+p["nc"], expiresAt)
+	if err != nil {
+		return kitcontext.Principal{}, err
+	}
+	if !ok {
+		return kitcontext.Principal{}, kiterrors.ErrNoCredentialsMatch.WithDetails("replayed nonce count")
+	}
+
+	return u.principal(kitcontext.AuthMethodDigest), nil
+}
+
+// AuthenticateDigest returns a middleware authenticating the Digest
+// credentials of the "Authorization" header, populated by
+// http.PopulateRequestAuthorizationToken or http.PopulateRequestCredentials.
+// The request method and URI are read from the context populated by
+// http.PopulateRequestTarget, which must be a ServerBefore as well. The
+// principal is added to the context like Authenticate does. Failures carry a
+// new challenge, sent by http.DefaultErrorEncoder.
+func AuthenticateDigest(a *DigestAuthenticator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			target := kitcontext.RequestTargetFromContext(ctx)
+			if target.Method == "" || target.URI == "" {
+				return nil, kiterrors.ErrInternalServerError.WithDetails("request target missing from context")
+			}
+
+			var err error
+			if params, ok := authorizationParams(ctx, "Digest"); !ok {
+				err = kiterrors.ErrAuthorizationHeaderMissing.WithDetails("missing Digest credentials")
+			} else {
+				var p kitcontext.Principal
+				if p, err = a.Authenticate(ctx, target.Method, target.URI, params); err == nil {
+					ctx = kitcontext.WithPrincipal(ctx, p)
+					return next(ctx, request)
+				}
+			}
+
+			challenge, cerr := a.Challenge(kiterrors.ErrTokenExpired.Equal(err))
+			if cerr != nil {
+				return nil, cerr
+			}
+
+			return nil, withChallenges(err, challenge)
+		}
+	}
+}
+
+// newNonce returns a nonce issued at t: the issue time and random bytes,
+// followed by their signature.
+func (a *DigestAuthenticator) newNonce(t time.Time) (string, error) {
+	b := make([]byte, 24, 24+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	if _, err := rand.Read(b[8:]); err != nil {
+		return "", kiterrors.WithStack(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(append(b, a.signNonce(b)...)), nil
+}
+
+// verifyNonce verifies the signature of nonce and returns its issue time.
+func (a *DigestAuthenticator) verifyNonce(nonce string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 24+sha256.Size {
+		return time.Time{}, false
+	}
+
+	if !hmac.Equal(b[24:], a.signNonce(b[:24])) {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), true
+}
+
+func (a *DigestAuthenticator) signNonce(b []byte) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(a.realm))
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// parseAuthParams parses comma separated authentication parameters, whose
+// values may be quoted strings.
+func parseAuthParams(s string) (map[string]string, bool) {
+	params := map[string]string{}
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, true
+		}
+
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, false
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i >= len(rest) {
+				return nil, false
+			}
+			s = rest[i+1:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+
+		params[name] = value.String()
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
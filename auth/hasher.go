@@ -1,16 +1,58 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/quocdaitrn/golang-kit/errors"
 )
 
-type Hasher struct{}
+// Hasher hashes passwords in the PHC string format. The zero value hashes
+// with Argon2id and default parameters.
+//
+// Hashes of the other supported algorithms, with any parameters, and legacy
+// bcrypt hashes produced by previous versions are still verified, Verify
+// reports them as needing a rehash.
+//
+// When Peppers is set, the salted password is first keyed with an HMAC-SHA256
+// of the pepper PepperVersion, and the version is recorded in the hash as the
+// "pv" parameter. Rotating the pepper is done by adding a version and making
+// it current: hashes of previous versions are still verified as long as their
+// pepper is kept, and are reported as needing a rehash.
+type Hasher struct {
+	// Algorithm hashes new passwords, DefaultArgon2id when nil.
+	Algorithm PasswordAlgorithm
+
+	// Peppers are the server-side secrets by version. They must be kept out
+	// of the database storing the hashes.
+	Peppers map[int][]byte
+
+	// PepperVersion is the version of the pepper applied to new hashes.
+	PepperVersion int
+}
+
+// pepperVersionParam is the PHC parameter recording the pepper version.
+const pepperVersionParam = "pv"
+
+var ErrPepperVersionUnknown = errors.New("unknown password pepper version")
+
+// VerifyResult is the result of a password verification.
+type VerifyResult struct {
+	// Valid reports whether the password matches the hash.
+	Valid bool
+
+	// NeedsRehash reports whether a valid password should be hashed again
+	// with HashPassword and the stored hash replaced, because it was produced
+	// by another algorithm, with other parameters or another pepper.
+	NeedsRehash bool
+}
 
 func (r *Hasher) RandomStr(length int) (string, error) {
 	var b = make([]byte, length)
@@ -25,18 +67,121 @@ func (r *Hasher) RandomStr(length int) (string, error) {
 }
 
 func (r *Hasher) HashPassword(salt, password string) (string, error) {
-	spStr := fmt.Sprintf("%s.%s", salt, password)
+	sp := saltedPassword(salt, password)
 
-	h, err := bcrypt.GenerateFromPassword([]byte(spStr), bcrypt.DefaultCost)
+	var pv string
+	if r.peppered() {
+		pepper, ok := r.Peppers[r.PepperVersion]
+		if !ok {
+			return "", errors.Errorf("%s: %d", ErrPepperVersionUnknown, r.PepperVersion)
+		}
+		sp = applyPepper(pepper, sp)
+		pv = strconv.Itoa(r.PepperVersion)
+	}
 
+	h, err := r.algorithm().Hash(sp)
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", err
 	}
 
-	return string(h), nil
+	if pv != "" {
+		h.Params = append(h.Params, PHCParam{Key: pepperVersionParam, Value: pv})
+	}
+
+	return h.String(), nil
 }
 
 func (r *Hasher) CompareHashPassword(hashedPassword, salt, password string) bool {
-	spStr := fmt.Sprintf("%s.%s", salt, password)
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(spStr)) == nil
+	res, err := r.Verify(hashedPassword, salt, password)
+	return err == nil && res.Valid
+}
+
+// Verify verifies password against hashedPassword and reports whether the
+// hash should be upgraded.
+func (r *Hasher) Verify(hashedPassword, salt, password string) (VerifyResult, error) {
+	sp := saltedPassword(salt, password)
+
+	if isLegacyBcrypt(hashedPassword) {
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), sp)
+		switch err {
+		case nil:
+			return VerifyResult{Valid: true, NeedsRehash: true}, nil
+		case bcrypt.ErrMismatchedHashAndPassword:
+			return VerifyResult{}, nil
+		default:
+			return VerifyResult{}, errors.WithStack(err)
+		}
+	}
+
+	h, err := ParsePHC(hashedPassword)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	current := r.algorithm()
+
+	alg := current
+	if h.ID != current.ID() {
+		var ok bool
+		if alg, ok = defaultPasswordAlgorithm(h.ID); !ok {
+			return VerifyResult{}, errors.Errorf("%s: %q", ErrPasswordAlgorithmUnknown, h.ID)
+		}
+	}
+
+	pv, peppered := h.Param(pepperVersionParam)
+	if peppered {
+		version, err := strconv.Atoi(pv)
+		if err != nil {
+			return VerifyResult{}, errors.Errorf("%s: invalid parameter %s", ErrPHCInvalid, pepperVersionParam)
+		}
+
+		pepper, ok := r.Peppers[version]
+		if !ok {
+			return VerifyResult{}, errors.Errorf("%s: %d", ErrPepperVersionUnknown, version)
+		}
+		sp = applyPepper(pepper, sp)
+	}
+
+	valid, err := alg.Verify(h, sp)
+	if err != nil || !valid {
+		return VerifyResult{}, err
+	}
+
+	needsRehash := current.NeedsRehash(h)
+	if r.peppered() {
+		needsRehash = needsRehash || pv != strconv.Itoa(r.PepperVersion)
+	} else {
+		needsRehash = needsRehash || peppered
+	}
+
+	return VerifyResult{Valid: true, NeedsRehash: needsRehash}, nil
+}
+
+func (r *Hasher) algorithm() PasswordAlgorithm {
+	if r.Algorithm == nil {
+		return DefaultArgon2id()
+	}
+
+	return r.Algorithm
+}
+
+func (r *Hasher) peppered() bool {
+	return len(r.Peppers) > 0
+}
+
+// applyPepper keys the salted password sp with pepper.
+func applyPepper(pepper, sp []byte) []byte {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write(sp)
+	return mac.Sum(nil)
+}
+
+func saltedPassword(salt, password string) []byte {
+	return []byte(fmt.Sprintf("%s.%s", salt, password))
+}
+
+// isLegacyBcrypt reports whether h is a bcrypt hash in the modular crypt
+// format, as produced by previous versions of Hasher.
+func isLegacyBcrypt(h string) bool {
+	return strings.HasPrefix(h, "$2a$") || strings.HasPrefix(h, "$2b$") || strings.HasPrefix(h, "$2y$")
 }
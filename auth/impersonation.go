@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/golang-jwt/jwt/v5"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	"github.com/quocdaitrn/golang-kit/internal/strutil"
+)
+
+const defaultImpersonationTTL = 15 * time.Minute
+
+// ImpersonationTargetResolver loads the current identity of the subjects
+// which can be impersonated, typically from the user database.
+type ImpersonationTargetResolver interface {
+	// ResolveClaims returns the claims of sub, or ErrRepoEntityNotFound.
+	ResolveClaims(ctx context.Context, sub string) (*Claims, error)
+}
+
+// ImpersonationOption configures an Impersonator.
+type ImpersonationOption func(*Impersonator)
+
+// WithImpersonationTTL sets the lifetime of impersonation tokens, 15 minutes
+// by default.
+func WithImpersonationTTL(d time.Duration) ImpersonationOption {
+	return func(i *Impersonator) {
+		i.ttl = d
+	}
+}
+
+// Impersonator issues impersonation tokens, which let an actor, such as a
+// support agent, act on behalf of another subject. The actor is recorded in
+// the "act" claim so that Authenticate populates it to the context and audit
+// code can recover it with kitcontext.ActorFromContext.
+type Impersonator struct {
+	provider ClaimsProvider
+	targets  ImpersonationTargetResolver
+	roles    []string
+	ttl      time.Duration
+}
+
+// NewImpersonator creates an Impersonator issuing tokens with p to actors
+// having any of roles, for the targets resolved by targets.
+func NewImpersonator(p ClaimsProvider, targets ImpersonationTargetResolver, roles []string, opts ...ImpersonationOption) *Impersonator {
+	i := &Impersonator{
+		provider: p,
+		targets:  targets,
+		roles:    roles,
+		ttl:      defaultImpersonationTTL,
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
+}
+
+// Impersonate issues a token for the subject sub on behalf of the user of the
+// context, which must have been authenticated with one of the allowed roles.
+// The claims of the token are the ones of sub resolved by the
+// ImpersonationTargetResolver. Users who are themselves impersonated can't
+// impersonate further, and subjects of other tenants or having one of the
+// allowed roles can't be impersonated.
+func (i *Impersonator) Impersonate(ctx context.Context, sub string) (token string, expSecs int, err error) {
+	uid := kitcontext.UIDFromContext(ctx)
+	if uid.IsZero() {
+		return "", 0, kiterrors.WithStack(kiterrors.ErrUnauthorized)
+	}
+
+	if uid.IsImpersonated() {
+		return "", 0, kiterrors.ErrForbidden.WithDetails("impersonated users can't impersonate")
+	}
+
+	if !hasAnyRole(kitcontext.RolesFromContext(ctx), i.roles) {
+		return "", 0, kiterrors.ErrInsufficientPermission.WithDetails(map[string][]string{"anyOf": i.roles})
+	}
+
+	if sub == "" || sub == uid.Sub {
+		return "", 0, kiterrors.ErrInvalidRequest.WithDetails("invalid impersonation target")
+	}
+
+	target, err := i.targets.ResolveClaims(ctx, sub)
+	if kiterrors.ErrRepoEntityNotFound.Equal(err) {
+		return "", 0, kiterrors.ErrInvalidRequest.WithDetails("unknown impersonation target")
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if target.Tid != uid.Tid {
+		return "", 0, kiterrors.ErrForbidden.WithDetails("impersonation target belongs to another tenant")
+	}
+
+	if hasAnyRole(target.Roles, i.roles) {
+		return "", 0, kiterrors.ErrForbidden.WithDetails("impersonation target can impersonate")
+	}
+
+	jti, err := randomString(16)
+	if err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now()
+
+	return i.provider.IssueTokenWithClaims(ctx, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   sub,
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.ttl)),
+		},
+		Tid:    target.Tid,
+		Roles:  target.Roles,
+		Scopes: target.Scopes,
+		Act:    &Actor{Sub: uid.Sub, Tid: uid.Tid},
+		Extra:  target.Extra,
+	})
+}
+
+// DenyImpersonation returns a middleware rejecting impersonated requests with
+// ErrForbidden, for operations only the user may perform, such as changing
+// its password. It must be chained after Authenticate.
+func DenyImpersonation() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if kitcontext.UIDFromContext(ctx).IsImpersonated() {
+				return nil, kiterrors.ErrForbidden.WithDetails("not allowed while impersonating")
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+func hasAnyRole(granted, roles []string) bool {
+	for _, r := range roles {
+		if strutil.Contains(granted, r) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+const (
+	defaultIntrospectionCacheSize   = 10000
+	defaultIntrospectionMaxCacheTTL = 5 * time.Minute
+	defaultIntrospectionNegativeTTL = 10 * time.Second
+	defaultIntrospectionTimeout     = 10 * time.Second
+)
+
+// IntrospectionResponse is the response of an OAuth 2.0 token introspection
+// endpoint as described in RFC 7662. Tid is a non standard extension carrying
+// the tenant of the subject.
+type IntrospectionResponse struct {
+	Active    bool             `json:"active"`
+	Scope     SpaceDelimited   `json:"scope,omitempty"`
+	ClientID  string           `json:"client_id,omitempty"`
+	Username  string           `json:"username,omitempty"`
+	TokenType string           `json:"token_type,omitempty"`
+	Exp       int64            `json:"exp,omitempty"`
+	Iat       int64            `json:"iat,omitempty"`
+	Nbf       int64            `json:"nbf,omitempty"`
+	Sub       string           `json:"sub,omitempty"`
+	Aud       jwt.ClaimStrings `json:"aud,omitempty"`
+	Iss       string           `json:"iss,omitempty"`
+	Jti       string           `json:"jti,omitempty"`
+	Tid       string           `json:"tid,omitempty"`
+	Roles     []string         `json:"roles,omitempty"`
+	Act       *Actor           `json:"act,omitempty"`
+}
+
+// IntrospectionOption configures an introspection client.
+type IntrospectionOption func(*introspectionClient)
+
+// WithIntrospectionHTTPClient sets the client used to call the endpoint.
+func WithIntrospectionHTTPClient(c *http.Client) IntrospectionOption {
+	return func(ic *introspectionClient) {
+		ic.client = c
+	}
+}
+
+// WithIntrospectionCacheSize sets the maximum number of cached results, zero
+// disables caching.
+func WithIntrospectionCacheSize(size int) IntrospectionOption {
+	return func(ic *introspectionClient) {
+		ic.cacheSize = size
+	}
+}
+
+// WithIntrospectionMaxCacheTTL bounds how long an active result is cached,
+// even when the token expires later.
+func WithIntrospectionMaxCacheTTL(d time.Duration) IntrospectionOption {
+	return func(ic *introspectionClient) {
+		ic.maxCacheTTL = d
+	}
+}
+
+// WithIntrospectionNegativeTTL sets how long inactive results are cached.
+func WithIntrospectionNegativeTTL(d time.Duration) IntrospectionOption {
+	return func(ic *introspectionClient) {
+		ic.negativeTTL = d
+	}
+}
+
+// introspectionClient is an AuthenticateClient calling a remote RFC 7662
+// token introspection endpoint.
+type introspectionClient struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	cacheSize    int
+	maxCacheTTL  time.Duration
+	negativeTTL  time.Duration
+
+	cache *lruCache
+	group callGroup
+}
+
+// NewIntrospectionClient creates an AuthenticateClient which introspects
+// tokens at endpoint, authenticating with the client credentials. Active
+// results are cached until the token expires, inactive ones briefly, and
+// concurrent lookups of the same token share one request.
+func NewIntrospectionClient(endpoint, clientID, clientSecret string, opts ...IntrospectionOption) *introspectionClient {
+	ic := &introspectionClient{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       http.DefaultClient,
+		cacheSize:    defaultIntrospectionCacheSize,
+		maxCacheTTL:  defaultIntrospectionMaxCacheTTL,
+		negativeTTL:  defaultIntrospectionNegativeTTL,
+	}
+
+	for _, opt := range opts {
+		opt(ic)
+	}
+
+	ic.cache = newLRUCache(ic.cacheSize)
+
+	return ic
+}
+
+// IntrospectToken returns the subject and tenant of an active token.
+func (ic *introspectionClient) IntrospectToken(ctx context.Context, accessToken string) (sub string, tid string, err error) {
+	resp, err := ic.Introspect(ctx, accessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	return resp.Sub, resp.Tid, nil
+}
+
+// IntrospectTokenClaims returns the claims of an active token.
+func (ic *introspectionClient) IntrospectTokenClaims(ctx context.Context, accessToken string) (claims *Claims, err error) {
+	resp, err := ic.Introspect(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Claims(), nil
+}
+
+// Claims converts the introspection result to token claims.
+func (r *IntrospectionResponse) Claims() *Claims {
+	c := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   r.Iss,
+			Subject:  r.Sub,
+			Audience: r.Aud,
+			ID:       r.Jti,
+		},
+		Tid:    r.Tid,
+		Roles:  r.Roles,
+		Scopes: r.Scope,
+		Act:    r.Act,
+	}
+
+	if r.Exp > 0 {
+		c.ExpiresAt = jwt.NewNumericDate(time.Unix(r.Exp, 0))
+	}
+	if r.Iat > 0 {
+		c.IssuedAt = jwt.NewNumericDate(time.Unix(r.Iat, 0))
+	}
+	if r.Nbf > 0 {
+		c.NotBefore = jwt.NewNumericDate(time.Unix(r.Nbf, 0))
+	}
+
+	return c
+}
+
+// Introspect returns the introspection result of an active token. Inactive
+// tokens and tokens not valid yet yield ErrBearerTokenInvalid, active tokens
+// without subject ErrUnrecognizableToken.
+func (ic *introspectionClient) Introspect(ctx context.Context, accessToken string) (*IntrospectionResponse, error) {
+	sum := sha256.Sum256([]byte(accessToken))
+	key := hex.EncodeToString(sum[:])
+
+	v, ok := ic.cache.get(key)
+	if !ok {
+		var err error
+		v, err = ic.group.do(ctx, key, defaultIntrospectionTimeout, func(ctx context.Context) (interface{}, error) {
+			resp, err := ic.call(ctx, accessToken)
+			if err != nil {
+				return nil, err
+			}
+
+			ic.cache.set(key, resp, ic.ttl(resp))
+			return resp, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp := v.(*IntrospectionResponse)
+
+	now := time.Now().Unix()
+	switch {
+	case !resp.Active, resp.Exp > 0 && now >= resp.Exp:
+		return nil, kiterrors.ErrBearerTokenInvalid.WithDetails("token is not active")
+	case resp.Nbf > 0 && now < resp.Nbf:
+		return nil, kiterrors.ErrBearerTokenInvalid.WithDetails("token is not valid yet")
+	case resp.Sub == "":
+		return nil, kiterrors.ErrUnrecognizableToken.WithDetails("missing subject")
+	}
+
+	return resp, nil
+}
+
+// ttl returns how long resp may be cached.
+func (ic *introspectionClient) ttl(resp *IntrospectionResponse) time.Duration {
+	if !resp.Active {
+		return ic.negativeTTL
+	}
+
+	ttl := ic.maxCacheTTL
+	if resp.Exp > 0 {
+		if untilExp := time.Until(time.Unix(resp.Exp, 0)); untilExp < ttl {
+			ttl = untilExp
+		}
+	}
+
+	return ttl
+}
+
+// call requests the introspection endpoint.
+func (ic *introspectionClient) call(ctx context.Context, accessToken string) (*IntrospectionResponse, error) {
+	form := url.Values{
+		"token":           {accessToken},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ic.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, kiterrors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(ic.clientID), url.QueryEscape(ic.clientSecret))
+
+	resp, err := ic.client.Do(req)
+	if err != nil {
+		return nil, kiterrors.ErrServiceUnavailable.WithDetails(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, kiterrors.ErrBadGateway.WithDetails(kiterrors.Errorf("introspection endpoint responded with status %d", resp.StatusCode))
+	}
+
+	var ir IntrospectionResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBodySize)).Decode(&ir); err != nil {
+		return nil, kiterrors.ErrBadGateway.WithDetails(err)
+	}
+
+	return &ir, nil
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// introspectionServer is an introspection endpoint answering with the
+// response registered for each token. Requests block while gate is set.
+type introspectionServer struct {
+	*httptest.Server
+
+	responses map[string]IntrospectionResponse
+	gate      chan struct{}
+	started   chan struct{}
+	calls     int32
+}
+
+func newIntrospectionServer(t *testing.T, responses map[string]IntrospectionResponse) *introspectionServer {
+	t.Helper()
+
+	s := &introspectionServer{responses: responses, started: make(chan struct{}, 16)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.calls, 1)
+		s.started <- struct{}{}
+
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if s.gate != nil {
+			<-s.gate
+		}
+
+		_ = json.NewEncoder(w).Encode(s.responses[r.PostFormValue("token")])
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestIntrospectionClientCachesResults(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	srv := newIntrospectionServer(t, map[string]IntrospectionResponse{
+		"active": {Active: true, Sub: "alice", Tid: "acme", Exp: exp, Scope: SpaceDelimited{"read"}},
+	})
+
+	ic := NewIntrospectionClient(srv.URL, "client", "secret")
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		c, err := ic.IntrospectTokenClaims(ctx, "active")
+		if err != nil {
+			t.Fatalf("introspect: %v", err)
+		}
+		if c.Subject != "alice" || c.Tid != "acme" {
+			t.Fatalf("claims = %+v", c)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := ic.Introspect(ctx, "unknown"); !kiterrors.ErrBearerTokenInvalid.Equal(err) {
+			t.Fatalf("introspect inactive token: %v, want ErrBearerTokenInvalid", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&srv.calls); n != 2 {
+		t.Errorf("calls = %d, want 2", n)
+	}
+}
+
+func TestIntrospectionClientRejectsInvalidResults(t *testing.T) {
+	now := time.Now()
+	srv := newIntrospectionServer(t, map[string]IntrospectionResponse{
+		"expired":   {Active: true, Sub: "alice", Exp: now.Add(-time.Minute).Unix()},
+		"notyet":    {Active: true, Sub: "alice", Nbf: now.Add(time.Hour).Unix()},
+		"nosubject": {Active: true, ClientID: "billing"},
+		"inactive":  {Active: false, Sub: "alice"},
+		"active":    {Active: true, Sub: "alice"},
+	})
+
+	ic := NewIntrospectionClient(srv.URL, "client", "secret")
+
+	tests := []struct {
+		token string
+		want  interface{ Equal(error) bool }
+	}{
+		{"expired", kiterrors.ErrBearerTokenInvalid},
+		{"notyet", kiterrors.ErrBearerTokenInvalid},
+		{"nosubject", kiterrors.ErrUnrecognizableToken},
+		{"inactive", kiterrors.ErrBearerTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			if _, err := ic.Introspect(context.Background(), tt.token); !tt.want.Equal(err) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	bad := NewIntrospectionClient(srv.URL, "client", "wrong")
+	if _, err := bad.Introspect(context.Background(), "active"); !kiterrors.ErrBadGateway.Equal(err) {
+		t.Errorf("err = %v, want ErrBadGateway", err)
+	}
+}
+
+func TestIntrospectionClientCoalescesConcurrentLookups(t *testing.T) {
+	srv := newIntrospectionServer(t, map[string]IntrospectionResponse{
+		"active": {Active: true, Sub: "alice", Exp: time.Now().Add(time.Hour).Unix()},
+	})
+	srv.gate = make(chan struct{})
+
+	ic := NewIntrospectionClient(srv.URL, "client", "secret")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ic.Introspect(context.Background(), "active")
+			errs <- err
+		}()
+	}
+
+	<-srv.started
+	time.Sleep(20 * time.Millisecond)
+	close(srv.gate)
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("introspect: %v", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&srv.calls); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+func TestIntrospectionClientSurvivesCanceledCaller(t *testing.T) {
+	srv := newIntrospectionServer(t, map[string]IntrospectionResponse{
+		"active": {Active: true, Sub: "alice", Exp: time.Now().Add(time.Hour).Unix()},
+	})
+	srv.gate = make(chan struct{})
+
+	ic := NewIntrospectionClient(srv.URL, "client", "secret")
+
+	// The first caller starts the request, then gives up.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := ic.Introspect(ctx, "active")
+		first <- err
+	}()
+
+	<-srv.started
+	cancel()
+	if err := <-first; !kiterrors.Is(err, context.Canceled) {
+		t.Fatalf("canceled caller: %v, want context.Canceled", err)
+	}
+
+	// A second caller joins the request still in flight.
+	second := make(chan error, 1)
+	go func() {
+		_, err := ic.Introspect(context.Background(), "active")
+		second <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(srv.gate)
+
+	if err := <-second; err != nil {
+		t.Fatalf("second caller: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&srv.calls); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
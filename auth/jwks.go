@@ -0,0 +1,559 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/quocdaitrn/golang-kit/errors"
+)
+
+const (
+	defaultJWKSMinRefreshInterval = 30 * time.Second
+	defaultJWKSCacheTTL           = 10 * time.Minute
+	defaultJWKSHandlerMaxAge      = 5 * time.Minute
+
+	// maxResponseBodySize bounds the responses read from remote issuers and
+	// token endpoints.
+	maxResponseBodySize = 4 << 20
+)
+
+var (
+	ErrJWKSURLMissing     = errors.New("JWKS URL must be specified")
+	ErrJWKUnsupported     = errors.New("unsupported JSON web key")
+	ErrJWKSFetchFailed    = errors.New("failed to fetch JWKS")
+	ErrJWKSRefreshLimited = errors.New("JWKS refresh is rate limited")
+)
+
+// JWK is a public JSON Web Key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySetProvider is implemented by providers which can publish their
+// verification keys.
+type KeySetProvider interface {
+	JWKS() JWKSet
+}
+
+// JWKS returns the public verification keys of the provider. Symmetric keys
+// are never published.
+func (j *jwtx) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	ring, err := j.keys()
+	if err != nil {
+		return set
+	}
+
+	for _, k := range ring.valid(time.Now()) {
+		if jwk, ok := newJWK(k); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+// JWKSHandler returns a http.Handler serving the key set of p, typically
+// mounted at "/.well-known/jwks.json". maxAge controls the Cache-Control
+// header, zero uses a default of 5 minutes.
+func JWKSHandler(p KeySetProvider, maxAge time.Duration) http.Handler {
+	if maxAge <= 0 {
+		maxAge = defaultJWKSHandlerMaxAge
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+		_ = json.NewEncoder(w).Encode(p.JWKS())
+	})
+}
+
+// newJWK converts the public part of k to a JWK. It reports false for
+// symmetric keys.
+func newJWK(k *signingKey) (JWK, bool) {
+	if k == nil {
+		return JWK{}, false
+	}
+
+	jwk := JWK{Kid: k.kid, Use: "sig"}
+	if k.method != nil {
+		jwk.Alg = k.method.Alg()
+	}
+
+	switch pub := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// PublicKey decodes the public key held by the JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.WithStack(ErrJWKUnsupported)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.WithStack(ErrJWKUnsupported)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.WithStack(ErrJWKUnsupported)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.WithStack(ErrJWKUnsupported)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.WithStack(ErrJWKUnsupported)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.WithStack(ErrJWKUnsupported)
+	}
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKSOption configures a JWKS backed provider.
+type JWKSOption func(*jwksProvider)
+
+// WithJWKSURL sets the URL of the key set.
+func WithJWKSURL(url string) JWKSOption {
+	return func(p *jwksProvider) {
+		p.url = url
+	}
+}
+
+// WithJWKSHTTPClient sets the client used to fetch the key set.
+func WithJWKSHTTPClient(c *http.Client) JWKSOption {
+	return func(p *jwksProvider) {
+		p.client = c
+	}
+}
+
+// WithJWKSMinRefreshInterval sets the minimum time between two fetches of the
+// key set, which bounds how often unknown key ids can trigger a refresh.
+func WithJWKSMinRefreshInterval(d time.Duration) JWKSOption {
+	return func(p *jwksProvider) {
+		p.minRefreshInterval = d
+	}
+}
+
+// WithJWKSIssuer sets the issuer required on parse.
+func WithJWKSIssuer(iss string) JWKSOption {
+	return func(p *jwksProvider) {
+		p.issuer = iss
+	}
+}
+
+// WithJWKSAudience sets the audience required on parse.
+func WithJWKSAudience(aud string) JWKSOption {
+	return func(p *jwksProvider) {
+		p.audience = aud
+	}
+}
+
+// WithJWKSCacheTTL sets how long keys are cached when the issuer does not
+// send a Cache-Control max-age.
+func WithJWKSCacheTTL(d time.Duration) JWKSOption {
+	return func(p *jwksProvider) {
+		p.cacheTTL = d
+	}
+}
+
+// jwksProvider is a verifier-only JWTProvider which fetches its keys from a
+// remote JWKS endpoint and caches them by kid.
+type jwksProvider struct {
+	id                 string
+	url                string
+	issuer             string
+	audience           string
+	client             *http.Client
+	minRefreshInterval time.Duration
+	cacheTTL           time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]*signingKey
+	expiresAt time.Time
+	lastFetch time.Time
+
+	refreshMu sync.Mutex
+}
+
+// NewJWKSProvider creates a JWTProvider which verifies tokens against the
+// keys published at a JWKS URL. It cannot issue tokens.
+func NewJWKSProvider(id string, opts ...JWKSOption) *jwksProvider {
+	p := &jwksProvider{
+		id:                 id,
+		client:             http.DefaultClient,
+		minRefreshInterval: defaultJWKSMinRefreshInterval,
+		cacheTTL:           defaultJWKSCacheTTL,
+		keys:               map[string]*signingKey{},
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *jwksProvider) ID() string {
+	return p.id
+}
+
+func (p *jwksProvider) InitFlags() {
+	flag.StringVar(
+		&p.url,
+		"jwks-url",
+		p.url,
+		"URL of the JSON Web Key Set used to verify JWT",
+	)
+
+	flag.StringVar(
+		&p.issuer,
+		"jwks-issuer",
+		p.issuer,
+		"Issuer required when parsing JWT, not checked when empty",
+	)
+
+	flag.StringVar(
+		&p.audience,
+		"jwks-audience",
+		p.audience,
+		"Audience required when parsing JWT, not checked when empty",
+	)
+
+	flag.DurationVar(
+		&p.minRefreshInterval,
+		"jwks-min-refresh-interval",
+		p.minRefreshInterval,
+		"Minimum interval between two JWKS fetches",
+	)
+
+	flag.DurationVar(
+		&p.cacheTTL,
+		"jwks-cache-ttl",
+		p.cacheTTL,
+		"Time to cache JWKS when the response has no Cache-Control max-age",
+	)
+}
+
+func (p *jwksProvider) Activate() error {
+	if p.url == "" {
+		return errors.WithStack(ErrJWKSURLMissing)
+	}
+
+	return nil
+}
+
+func (p *jwksProvider) Stop() error {
+	return nil
+}
+
+// JWKS returns the currently cached key set.
+func (p *jwksProvider) JWKS() JWKSet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range p.keys {
+		if jwk, ok := newJWK(k); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+func (p *jwksProvider) IssueToken(_ context.Context, _, _ string) (token string, expSecs int, err error) {
+	return "", 0, errors.WithStack(ErrSigningKeyMissing)
+}
+
+func (p *jwksProvider) IssueTokenWithClaims(_ context.Context, _ *Claims) (token string, expSecs int, err error) {
+	return "", 0, errors.WithStack(ErrSigningKeyMissing)
+}
+
+func (p *jwksProvider) ParseToken(ctx context.Context, tokenString string) (claims *jwt.RegisteredClaims, err error) {
+	c, err := p.ParseTokenWithClaims(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c.RegisteredClaims, nil
+}
+
+func (p *jwksProvider) ParseTokenWithClaims(ctx context.Context, tokenString string) (claims *Claims, err error) {
+	var c Claims
+
+	_, err = jwt.ParseWithClaims(tokenString, &c, func(token *jwt.Token) (interface{}, error) {
+		if isActionToken(token) {
+			return nil, ErrActionTokenType
+		}
+
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.method != nil && key.method.Alg() != token.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		if err := checkCurve(token.Method, key.verifyKey); err != nil {
+			return nil, err
+		}
+
+		return key.verifyKey, nil
+	}, parserOptions(p.issuer, p.audience)...)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := checkNotActionToken(&c); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &c, nil
+}
+
+// key returns the verification key for kid, refreshing the key set when the
+// cache is stale or the kid is unknown.
+func (p *jwksProvider) key(ctx context.Context, kid string) (*signingKey, error) {
+	key, fresh := p.cachedKey(kid)
+	if key != nil && fresh {
+		return key, nil
+	}
+
+	if err := p.refresh(ctx); err != nil {
+		// Keep serving stale keys while the issuer is unreachable.
+		if key != nil {
+			return key, nil
+		}
+
+		return nil, err
+	}
+
+	if key, _ = p.cachedKey(kid); key == nil {
+		return nil, ErrUnknownKeyID
+	}
+
+	return key, nil
+}
+
+// cachedKey looks up kid in the cache. An empty kid matches the only key of a
+// single-key set.
+func (p *jwksProvider) cachedKey(kid string) (key *signingKey, fresh bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	fresh = time.Now().Before(p.expiresAt)
+
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, fresh
+		}
+	}
+
+	return p.keys[kid], fresh
+}
+
+// refresh fetches the key set unless another fetch happened within the
+// minimum refresh interval.
+func (p *jwksProvider) refresh(ctx context.Context) error {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	p.mu.RLock()
+	lastFetch := p.lastFetch
+	p.mu.RUnlock()
+
+	if time.Since(lastFetch) < p.minRefreshInterval {
+		return errors.WithStack(ErrJWKSRefreshLimited)
+	}
+
+	keys, ttl, err := p.fetch(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastFetch = time.Now()
+	if err != nil {
+		return err
+	}
+
+	p.keys = keys
+	p.expiresAt = p.lastFetch.Add(ttl)
+
+	return nil
+}
+
+// fetch downloads and decodes the key set. Keys which cannot be decoded are
+// skipped so one unsupported key does not break verification of the others.
+func (p *jwksProvider) fetch(ctx context.Context) (map[string]*signingKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.Errorf("%s: unexpected status %d", ErrJWKSFetchFailed, resp.StatusCode)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBodySize)).Decode(&set); err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	keys := map[string]*signingKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		k := &signingKey{kid: jwk.Kid, verifyKey: pub}
+		if jwk.Alg != "" {
+			if k.method = jwt.GetSigningMethod(jwk.Alg); k.method == nil {
+				continue
+			}
+		}
+
+		keys[jwk.Kid] = k
+	}
+
+	return keys, cacheTTL(resp.Header.Get("Cache-Control"), p.cacheTTL), nil
+}
+
+// cacheTTL extracts max-age from a Cache-Control header value. no-store and
+// no-cache yield zero, a missing max-age yields def.
+func cacheTTL(cc string, def time.Duration) time.Duration {
+	for _, directive := range strings.Split(cc, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-store", directive == "no-cache":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && secs >= 0 {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+
+	return def
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newECKeyConfig generates a P-256 key with kid.
+func newECKeyConfig(t *testing.T, kid string) KeyConfig {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return KeyConfig{
+		Kid:        kid,
+		Alg:        "ES256",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+}
+
+// newTestJWT creates an activated provider signing with the active key of
+// cfg.
+func newTestJWT(t *testing.T, cfg KeyRingConfig, opts ...JWTOption) *jwtx {
+	t.Helper()
+
+	j := NewJWT("jwt", append([]JWTOption{WithKeyRing(cfg)}, opts...)...)
+	j.expireTokenInSeconds = 3600
+	if err := j.Activate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return j
+}
+
+// jwksServer serves the key set of an issuer, failing while down is set.
+type jwksServer struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	issuer       KeySetProvider
+	cacheControl string
+	down         bool
+	fetches      int32
+}
+
+func newJWKSServer(t *testing.T, issuer KeySetProvider, cacheControl string) *jwksServer {
+	s := &jwksServer{issuer: issuer, cacheControl: cacheControl}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", s.cacheControl)
+		_ = json.NewEncoder(w).Encode(s.issuer.JWKS())
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *jwksServer) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func issueTestToken(t *testing.T, j *jwtx, sub string) string {
+	t.Helper()
+
+	token, _, err := j.IssueToken(context.Background(), "jti-"+sub, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestJWKSProviderFollowsKeyRotation(t *testing.T) {
+	k1, k2 := newECKeyConfig(t, "k1"), newECKeyConfig(t, "k2")
+
+	issuer := newTestJWT(t, KeyRingConfig{Active: "k1", Keys: []KeyConfig{k1}}, WithIssuer("https://issuer.test"))
+	srv := newJWKSServer(t, issuer, "max-age=300")
+
+	p := NewJWKSProvider("jwks",
+		WithJWKSURL(srv.URL),
+		WithJWKSIssuer("https://issuer.test"),
+		WithJWKSMinRefreshInterval(0),
+	)
+	if err := p.Activate(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	before := issueTestToken(t, issuer, "alice")
+	if _, err := p.ParseTokenWithClaims(ctx, before); err != nil {
+		t.Fatalf("parse token of k1: %v", err)
+	}
+
+	// Rotate to k2, keeping k1 for verification.
+	if err := issuer.RotateKeys(KeyRingConfig{Active: "k2", Keys: []KeyConfig{k2, k1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	after := issueTestToken(t, issuer, "bob")
+	c, err := p.ParseTokenWithClaims(ctx, after)
+	if err != nil {
+		t.Fatalf("parse token of k2: %v", err)
+	}
+	if c.Subject != "bob" {
+		t.Errorf("subject = %q, want bob", c.Subject)
+	}
+
+	if _, err := p.ParseTokenWithClaims(ctx, before); err != nil {
+		t.Errorf("parse token of k1 after rotation: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&srv.fetches); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
+
+func TestJWKSProviderServesStaleKeysWhileIssuerIsDown(t *testing.T) {
+	issuer := newTestJWT(t, KeyRingConfig{Active: "k1", Keys: []KeyConfig{newECKeyConfig(t, "k1")}})
+	srv := newJWKSServer(t, issuer, "no-cache")
+
+	p := NewJWKSProvider("jwks", WithJWKSURL(srv.URL), WithJWKSMinRefreshInterval(0))
+
+	ctx := context.Background()
+	token := issueTestToken(t, issuer, "alice")
+
+	if _, err := p.ParseTokenWithClaims(ctx, token); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	srv.setDown(true)
+
+	if _, err := p.ParseTokenWithClaims(ctx, token); err != nil {
+		t.Fatalf("parse with stale keys: %v", err)
+	}
+	if n := atomic.LoadInt32(&srv.fetches); n != 2 {
+		t.Errorf("fetches = %d, want a refresh attempt of the stale set", n)
+	}
+
+	// Keys unknown to the stale set can't be verified.
+	other := newTestJWT(t, KeyRingConfig{Active: "k2", Keys: []KeyConfig{newECKeyConfig(t, "k2")}})
+	if _, err := p.ParseTokenWithClaims(ctx, issueTestToken(t, other, "bob")); err == nil {
+		t.Error("parse token of unknown key succeeded while issuer is down")
+	}
+}
+
+func TestJWKSProviderRateLimitsUnknownKeyIDs(t *testing.T) {
+	issuer := newTestJWT(t, KeyRingConfig{Active: "k1", Keys: []KeyConfig{newECKeyConfig(t, "k1")}})
+	srv := newJWKSServer(t, issuer, "max-age=300")
+
+	p := NewJWKSProvider("jwks", WithJWKSURL(srv.URL), WithJWKSMinRefreshInterval(time.Hour))
+
+	ctx := context.Background()
+	if _, err := p.ParseTokenWithClaims(ctx, issueTestToken(t, issuer, "alice")); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	other := newTestJWT(t, KeyRingConfig{Active: "k2", Keys: []KeyConfig{newECKeyConfig(t, "k2")}})
+	for i := 0; i < 3; i++ {
+		if _, err := p.ParseTokenWithClaims(ctx, issueTestToken(t, other, "bob")); err == nil {
+			t.Fatal("parse token of unknown key succeeded")
+		}
+	}
+
+	if n := atomic.LoadInt32(&srv.fetches); n != 1 {
+		t.Errorf("fetches = %d, want 1", n)
+	}
+}
+
+func TestJWKSProviderRejectsHMACTokens(t *testing.T) {
+	issuer := newTestJWT(t, KeyRingConfig{Active: "k1", Keys: []KeyConfig{newECKeyConfig(t, "k1")}})
+	srv := newJWKSServer(t, issuer, "max-age=300")
+
+	p := NewJWKSProvider("jwks", WithJWKSURL(srv.URL))
+
+	hs := newTestJWT(t, KeyRingConfig{Keys: []KeyConfig{{Kid: "k1", Alg: "HS256", Secret: "0123456789abcdef0123456789abcdef"}}})
+	if _, err := p.ParseTokenWithClaims(context.Background(), issueTestToken(t, hs, "alice")); err == nil {
+		t.Error("HS256 token accepted by JWKS provider")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -16,24 +17,84 @@ type JWTProvider interface {
 	ParseToken(ctx context.Context, tokenString string) (claims *jwt.RegisteredClaims, err error)
 }
 
+// ClaimsProvider is implemented by JWTProviders which can issue and parse the
+// full Claims of a token, such as the providers created by NewJWT and
+// NewJWKSProvider.
+type ClaimsProvider interface {
+	// IssueTokenWithClaims signs claims. Zero time claims are set from the
+	// token lifetime and empty issuer and audience from the configuration.
+	IssueTokenWithClaims(ctx context.Context, claims *Claims) (token string, expSecs int, err error)
+
+	// ParseTokenWithClaims verifies the token, including the configured
+	// issuer and audience, and returns its claims.
+	ParseTokenWithClaims(ctx context.Context, tokenString string) (claims *Claims, err error)
+}
+
 const (
 	defaultSecret               = "very-important-please-change-it!" // in 32 bytes
 	defaultExpireTokenInSeconds = 60 * 60 * 24 * 7                   // 7d
+	defaultAlgorithm            = "HS256"
 )
 
 var (
 	ErrSecretKeyNotValid     = errors.New("secret key must be in 32 bytes")
 	ErrTokenLifeTimeTooShort = errors.New("token life time too short")
+	ErrUnknownKeyID          = errors.New("unknown key id")
 )
 
 type jwtx struct {
 	id                   string
+	alg                  string
+	kid                  string
 	secret               string
+	privateKey           string
+	privateKeyFile       string
+	publicKey            string
+	publicKeyFile        string
+	keyRingFile          string
+	keyRingConfig        *KeyRingConfig
+	issuer               string
+	audience             string
 	expireTokenInSeconds int
+
+	mu   sync.RWMutex
+	ring *keyRing
+}
+
+// JWTOption configures a JWT provider created by NewJWT.
+type JWTOption func(*jwtx)
+
+// WithKeyRing configures the provider with a key ring instead of the key
+// flags.
+func WithKeyRing(cfg KeyRingConfig) JWTOption {
+	return func(j *jwtx) {
+		j.keyRingConfig = &cfg
+	}
+}
+
+// WithIssuer sets the issuer stamped in issued tokens and required on parse.
+func WithIssuer(iss string) JWTOption {
+	return func(j *jwtx) {
+		j.issuer = iss
+	}
+}
+
+// WithAudience sets the audience stamped in issued tokens and required on
+// parse.
+func WithAudience(aud string) JWTOption {
+	return func(j *jwtx) {
+		j.audience = aud
+	}
 }
 
-func NewJWT(id string) *jwtx {
-	return &jwtx{id: id}
+func NewJWT(id string, opts ...JWTOption) *jwtx {
+	j := &jwtx{id: id}
+
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	return j
 }
 
 func (j *jwtx) ID() string {
@@ -41,6 +102,20 @@ func (j *jwtx) ID() string {
 }
 
 func (j *jwtx) InitFlags() {
+	flag.StringVar(
+		&j.alg,
+		"jwt-alg",
+		defaultAlgorithm,
+		"Algorithm to sign JWT: HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA",
+	)
+
+	flag.StringVar(
+		&j.kid,
+		"jwt-kid",
+		"",
+		"Key ID stamped in the JWT header, derived from the public key when empty",
+	)
+
 	flag.StringVar(
 		&j.secret,
 		"jwt-secret",
@@ -48,6 +123,55 @@ func (j *jwtx) InitFlags() {
 		"Secret key to sign JWT",
 	)
 
+	flag.StringVar(
+		&j.privateKey,
+		"jwt-private-key",
+		"",
+		"PEM encoded private key to sign JWT, takes precedence over jwt-private-key-file",
+	)
+
+	flag.StringVar(
+		&j.privateKeyFile,
+		"jwt-private-key-file",
+		"",
+		"Path to the PEM encoded private key to sign JWT",
+	)
+
+	flag.StringVar(
+		&j.publicKey,
+		"jwt-public-key",
+		"",
+		"PEM encoded public key to verify JWT, takes precedence over jwt-public-key-file",
+	)
+
+	flag.StringVar(
+		&j.publicKeyFile,
+		"jwt-public-key-file",
+		"",
+		"Path to the PEM encoded public key to verify JWT",
+	)
+
+	flag.StringVar(
+		&j.keyRingFile,
+		"jwt-keyring-file",
+		"",
+		"Path to a JSON key ring file with an active key and previous keys still valid for verification, overrides the other key flags",
+	)
+
+	flag.StringVar(
+		&j.issuer,
+		"jwt-issuer",
+		j.issuer,
+		"Issuer stamped in JWT and required when parsing, not checked when empty",
+	)
+
+	flag.StringVar(
+		&j.audience,
+		"jwt-audience",
+		j.audience,
+		"Audience stamped in JWT and required when parsing, not checked when empty",
+	)
+
 	flag.IntVar(
 		&j.expireTokenInSeconds,
 		"jwt-exp-secs",
@@ -57,14 +181,19 @@ func (j *jwtx) InitFlags() {
 }
 
 func (j *jwtx) Activate() error {
-	if len(j.secret) < 32 {
-		return errors.WithStack(ErrSecretKeyNotValid)
+	ring, err := j.loadKeyRing()
+	if err != nil {
+		return err
 	}
 
 	if j.expireTokenInSeconds <= 60 {
 		return errors.WithStack(ErrTokenLifeTimeTooShort)
 	}
 
+	j.mu.Lock()
+	j.ring = ring
+	j.mu.Unlock()
+
 	return nil
 }
 
@@ -72,42 +201,173 @@ func (j *jwtx) Stop() error {
 	return nil
 }
 
+// RotateKeys atomically replaces the key ring. The new ring is validated the
+// same way as on Activate and the current one is kept when it is rejected.
+func (j *jwtx) RotateKeys(cfg KeyRingConfig) error {
+	ring, err := newKeyRing(cfg)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.ring = ring
+	j.mu.Unlock()
+
+	return nil
+}
+
+// keys returns the current key ring, or ErrKeyRingNotActivated before
+// Activate.
+func (j *jwtx) keys() (*keyRing, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if j.ring == nil {
+		return nil, errors.WithStack(ErrKeyRingNotActivated)
+	}
+
+	return j.ring, nil
+}
+
+// loadKeyRing builds the key ring from the option, the key ring file or the
+// single key flags, in that order.
+func (j *jwtx) loadKeyRing() (*keyRing, error) {
+	if j.keyRingConfig != nil {
+		return newKeyRing(*j.keyRingConfig)
+	}
+
+	if j.keyRingFile != "" {
+		cfg, err := readKeyRingFile(j.keyRingFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return newKeyRing(cfg)
+	}
+
+	return newKeyRing(KeyRingConfig{
+		Keys: []KeyConfig{{
+			Kid:            j.kid,
+			Alg:            j.alg,
+			Secret:         j.secret,
+			PrivateKey:     j.privateKey,
+			PrivateKeyFile: j.privateKeyFile,
+			PublicKey:      j.publicKey,
+			PublicKeyFile:  j.publicKeyFile,
+		}},
+	})
+}
+
 func (j *jwtx) IssueToken(ctx context.Context, id, sub string) (token string, expSecs int, err error) {
+	return j.IssueTokenWithClaims(ctx, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: sub,
+			ID:      id,
+		},
+	})
+}
+
+func (j *jwtx) IssueTokenWithClaims(_ context.Context, claims *Claims) (token string, expSecs int, err error) {
+	ring, err := j.keys()
+	if err != nil {
+		return "", 0, err
+	}
+
+	key, err := ring.signing()
+	if err != nil {
+		return "", 0, err
+	}
+
 	now := time.Now().UTC()
 
-	claims := jwt.RegisteredClaims{
-		Subject:   sub,
-		ExpiresAt: jwt.NewNumericDate(now.Add(time.Second * time.Duration(j.expireTokenInSeconds))),
-		NotBefore: jwt.NewNumericDate(now),
-		IssuedAt:  jwt.NewNumericDate(now),
-		ID:        id,
+	c := *claims
+	if c.IssuedAt == nil {
+		c.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if c.NotBefore == nil {
+		c.NotBefore = jwt.NewNumericDate(now)
+	}
+	if c.ExpiresAt == nil {
+		c.ExpiresAt = jwt.NewNumericDate(now.Add(time.Second * time.Duration(j.expireTokenInSeconds)))
+	}
+	if c.Issuer == "" {
+		c.Issuer = j.issuer
+	}
+	if len(c.Audience) == 0 && j.audience != "" {
+		c.Audience = jwt.ClaimStrings{j.audience}
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	t := jwt.NewWithClaims(key.method, c)
+	if key.kid != "" {
+		t.Header["kid"] = key.kid
+	}
 
-	tokenSignedStr, err := t.SignedString([]byte(j.secret))
+	tokenSignedStr, err := t.SignedString(key.signKey)
 
 	if err != nil {
 		return "", 0, errors.WithStack(err)
 	}
 
-	return tokenSignedStr, j.expireTokenInSeconds, nil
+	return tokenSignedStr, int(c.ExpiresAt.Unix() - now.Unix()), nil
 }
 
-func (j *jwtx) ParseToken(_ context.Context, tokenString string) (claims *jwt.RegisteredClaims, err error) {
-	var rc jwt.RegisteredClaims
+func (j *jwtx) ParseToken(ctx context.Context, tokenString string) (claims *jwt.RegisteredClaims, err error) {
+	c, err := j.ParseTokenWithClaims(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c.RegisteredClaims, nil
+}
+
+func (j *jwtx) ParseTokenWithClaims(_ context.Context, tokenString string) (claims *Claims, err error) {
+	var c Claims
+
+	ring, err := j.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = jwt.ParseWithClaims(tokenString, &c, func(token *jwt.Token) (interface{}, error) {
+		if isActionToken(token) {
+			return nil, ErrActionTokenType
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := ring.verifying(kid, time.Now())
+		if err != nil {
+			return nil, err
+		}
 
-	token, err := jwt.ParseWithClaims(tokenString, &rc, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return []byte(j.secret), nil
-	})
+		return key.verifyKey, nil
+	}, parserOptions(j.issuer, j.audience)...)
 
-	if !token.Valid {
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return &rc, nil
+	if err := checkNotActionToken(&c); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &c, nil
+}
+
+// parserOptions returns the options enforcing issuer and audience when they
+// are configured.
+func parserOptions(iss, aud string) []jwt.ParserOption {
+	var opts []jwt.ParserOption
+	if iss != "" {
+		opts = append(opts, jwt.WithIssuer(iss))
+	}
+	if aud != "" {
+		opts = append(opts, jwt.WithAudience(aud))
+	}
+
+	return opts
 }
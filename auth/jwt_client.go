@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// jwtAuthenticateClient is an AuthenticateClient which verifies tokens
+// locally with a JWTProvider instead of calling an identity service.
+type jwtAuthenticateClient struct {
+	provider JWTProvider
+}
+
+// NewJWTAuthenticateClient creates an AuthenticateClient which verifies
+// access tokens with p.
+func NewJWTAuthenticateClient(p JWTProvider) AuthenticateClient {
+	return &jwtAuthenticateClient{provider: p}
+}
+
+// IntrospectToken verifies accessToken and returns its subject and tenant.
+func (c *jwtAuthenticateClient) IntrospectToken(ctx context.Context, accessToken string) (sub string, tid string, err error) {
+	claims, err := c.IntrospectTokenClaims(ctx, accessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	return claims.Subject, claims.Tid, nil
+}
+
+// IntrospectTokenClaims verifies accessToken and returns its claims. Only
+// the registered claims are returned when the provider is not a
+// ClaimsProvider.
+func (c *jwtAuthenticateClient) IntrospectTokenClaims(ctx context.Context, accessToken string) (claims *Claims, err error) {
+	claims, err = c.parse(ctx, accessToken)
+	if err != nil {
+		return nil, jwtError(err)
+	}
+
+	if claims.Subject == "" {
+		return nil, kiterrors.ErrUnrecognizableToken.WithDetails("missing subject")
+	}
+
+	return claims, nil
+}
+
+func (c *jwtAuthenticateClient) parse(ctx context.Context, accessToken string) (*Claims, error) {
+	if cp, ok := c.provider.(ClaimsProvider); ok {
+		return cp.ParseTokenWithClaims(ctx, accessToken)
+	}
+
+	rc, err := c.provider.ParseToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Claims{RegisteredClaims: *rc}, nil
+}
+
+// jwtError translates an error of token parsing to a kit error.
+func jwtError(err error) error {
+	switch {
+	case kiterrors.Is(err, jwt.ErrTokenMalformed):
+		return kiterrors.ErrUnrecognizableToken.WithDetails(err)
+	case kiterrors.Is(err, jwt.ErrTokenExpired):
+		return kiterrors.ErrTokenExpired.WithDetails(err)
+	case kiterrors.Is(err, jwt.ErrTokenSignatureInvalid),
+		kiterrors.Is(err, jwt.ErrTokenUnverifiable),
+		kiterrors.Is(err, jwt.ErrTokenInvalidClaims):
+		return kiterrors.ErrBearerTokenInvalid.WithDetails(err)
+	default:
+		return kiterrors.ErrUnauthorized.WithDetails(err)
+	}
+}
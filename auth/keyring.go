@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/quocdaitrn/golang-kit/errors"
+)
+
+var (
+	ErrKeyRingEmpty             = errors.New("key ring must contain at least one key")
+	ErrKeyRingDuplicateKeyID    = errors.New("key ring contains duplicate key id")
+	ErrKeyRingKeyIDMissing      = errors.New("every key of a key ring with multiple keys must have a key id")
+	ErrKeyRingActiveKeyMissing  = errors.New("active key is not in the key ring")
+	ErrKeyRingActiveKeyRetiring = errors.New("active key must not have a retirement time")
+	ErrKeyRingActiveKeyNoSigner = errors.New("active key must hold a private key or secret")
+	ErrKeyRetired               = errors.New("key has been retired")
+	ErrKeyRingNotActivated      = errors.New("key ring is not loaded, the provider must be activated first")
+)
+
+// KeyConfig describes one key of a key ring. Secret is used by HS*
+// algorithms, the PEM fields by the asymmetric ones. RetireAt is the time
+// after which tokens signed with the key are no longer accepted, nil keeps
+// the key valid forever.
+type KeyConfig struct {
+	Kid            string     `json:"kid"`
+	Alg            string     `json:"alg"`
+	Secret         string     `json:"secret,omitempty"`
+	PrivateKey     string     `json:"private_key,omitempty"`
+	PrivateKeyFile string     `json:"private_key_file,omitempty"`
+	PublicKey      string     `json:"public_key,omitempty"`
+	PublicKeyFile  string     `json:"public_key_file,omitempty"`
+	RetireAt       *time.Time `json:"retire_at,omitempty"`
+}
+
+// KeyRingConfig is the content of a key ring file.
+type KeyRingConfig struct {
+	Active string      `json:"active"`
+	Keys   []KeyConfig `json:"keys"`
+}
+
+// keyRing holds one active key for signing and any number of previous keys
+// which remain valid for verification until their retirement time.
+type keyRing struct {
+	active   string
+	keys     map[string]*signingKey
+	retireAt map[string]time.Time
+}
+
+// newKeyRing loads and validates the keys of cfg.
+func newKeyRing(cfg KeyRingConfig) (*keyRing, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.WithStack(ErrKeyRingEmpty)
+	}
+
+	r := &keyRing{
+		active:   cfg.Active,
+		keys:     map[string]*signingKey{},
+		retireAt: map[string]time.Time{},
+	}
+
+	for _, kc := range cfg.Keys {
+		if kc.Kid == "" && len(cfg.Keys) > 1 {
+			return nil, errors.WithStack(ErrKeyRingKeyIDMissing)
+		}
+
+		k, err := kc.load()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := r.keys[k.kid]; ok {
+			return nil, errors.WithStack(ErrKeyRingDuplicateKeyID)
+		}
+
+		r.keys[k.kid] = k
+		if kc.RetireAt != nil {
+			r.retireAt[k.kid] = *kc.RetireAt
+		}
+
+		// A single key may leave the active kid implicit, which is how the
+		// flag based configuration is loaded.
+		if len(cfg.Keys) == 1 && r.active == "" && k.canSign() {
+			r.active = k.kid
+		}
+	}
+
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// validate rejects rings which cannot sign consistently. A ring without any
+// private key is a verifier-only ring and needs no active key.
+func (r *keyRing) validate() error {
+	canSign := false
+	for _, k := range r.keys {
+		canSign = canSign || k.canSign()
+	}
+
+	if r.active == "" && !canSign {
+		return nil
+	}
+
+	active, ok := r.keys[r.active]
+	if !ok {
+		return errors.WithStack(ErrKeyRingActiveKeyMissing)
+	}
+
+	if _, ok := r.retireAt[r.active]; ok {
+		return errors.WithStack(ErrKeyRingActiveKeyRetiring)
+	}
+
+	if canSign && !active.canSign() {
+		return errors.WithStack(ErrKeyRingActiveKeyNoSigner)
+	}
+
+	return nil
+}
+
+// signing returns the active key.
+func (r *keyRing) signing() (*signingKey, error) {
+	k, ok := r.keys[r.active]
+	if !ok || !k.canSign() {
+		return nil, errors.WithStack(ErrSigningKeyMissing)
+	}
+
+	return k, nil
+}
+
+// verifying returns the key selected by kid. Tokens without kid are verified
+// with the active key, or the only key of a single-key ring.
+func (r *keyRing) verifying(kid string, now time.Time) (*signingKey, error) {
+	if kid == "" {
+		kid = r.active
+		if len(r.keys) == 1 {
+			kid = r.single()
+		}
+	}
+
+	k, ok := r.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	if retireAt, ok := r.retireAt[kid]; ok && !now.Before(retireAt) {
+		return nil, ErrKeyRetired
+	}
+
+	return k, nil
+}
+
+// single returns the kid of a single-key ring.
+func (r *keyRing) single() string {
+	for kid := range r.keys {
+		return kid
+	}
+
+	return ""
+}
+
+// valid returns the keys which are not retired yet.
+func (r *keyRing) valid(now time.Time) []*signingKey {
+	keys := make([]*signingKey, 0, len(r.keys))
+	for kid, k := range r.keys {
+		if retireAt, ok := r.retireAt[kid]; ok && !now.Before(retireAt) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// load builds the signing key described by the config.
+func (kc KeyConfig) load() (*signingKey, error) {
+	alg := kc.Alg
+	if alg == "" {
+		alg = defaultAlgorithm
+	}
+
+	if strings.HasPrefix(alg, "HS") {
+		return newHMACKey(kc.Kid, alg, []byte(kc.Secret))
+	}
+
+	privatePEM, err := readPEM(kc.PrivateKey, kc.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	publicPEM, err := readPEM(kc.PublicKey, kc.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAsymmetricKey(kc.Kid, alg, privatePEM, publicPEM)
+}
+
+// readKeyRingFile reads a JSON encoded KeyRingConfig.
+func readKeyRingFile(file string) (KeyRingConfig, error) {
+	var cfg KeyRingConfig
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, errors.WithStack(err)
+	}
+
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, errors.WithStack(err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/quocdaitrn/golang-kit/errors"
+)
+
+var (
+	ErrSigningMethodNotSupported = errors.New("signing method is not supported")
+	ErrSigningKeyMissing         = errors.New("signing key is missing, this instance can only verify tokens")
+	ErrVerificationKeyMissing    = errors.New("verification key is missing")
+	ErrKeyTypeMismatch           = errors.New("key type does not match the signing method")
+	ErrKeyPairMismatch           = errors.New("public key does not match the private key")
+)
+
+// signingKey holds the key material used to sign and verify tokens with a
+// single algorithm. signKey is nil for verifier-only keys.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// canSign reports whether the key holds private key material.
+func (k *signingKey) canSign() bool {
+	return k.signKey != nil
+}
+
+// newHMACKey creates a signingKey for a HS* algorithm from a shared secret.
+func newHMACKey(kid, alg string, secret []byte) (*signingKey, error) {
+	method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, errors.WithStack(ErrSigningMethodNotSupported)
+	}
+
+	if len(secret) < 32 {
+		return nil, errors.WithStack(ErrSecretKeyNotValid)
+	}
+
+	return &signingKey{kid: kid, method: method, signKey: secret, verifyKey: secret}, nil
+}
+
+// newAsymmetricKey creates a signingKey for a RS*, PS*, ES* or EdDSA
+// algorithm. Either the private or the public key may be omitted, but not
+// both. When the public key is omitted it is derived from the private key,
+// otherwise it must match it.
+func newAsymmetricKey(kid, alg string, privatePEM, publicPEM []byte) (*signingKey, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, errors.WithStack(ErrSigningMethodNotSupported)
+	}
+
+	if len(privatePEM) == 0 && len(publicPEM) == 0 {
+		return nil, errors.WithStack(ErrVerificationKeyMissing)
+	}
+
+	var (
+		priv crypto.Signer
+		pub  crypto.PublicKey
+		err  error
+	)
+
+	if len(privatePEM) > 0 {
+		if priv, err = parsePrivateKeyPEM(method, privatePEM); err != nil {
+			return nil, err
+		}
+		pub = priv.Public()
+	}
+
+	if len(publicPEM) > 0 {
+		if pub, err = parsePublicKeyPEM(method, publicPEM); err != nil {
+			return nil, err
+		}
+
+		if priv != nil && !publicKeyEqual(priv.Public(), pub) {
+			return nil, errors.WithStack(ErrKeyPairMismatch)
+		}
+	}
+
+	if err := checkCurve(method, pub); err != nil {
+		return nil, err
+	}
+
+	if kid == "" {
+		if kid, err = publicKeyID(pub); err != nil {
+			return nil, err
+		}
+	}
+
+	k := &signingKey{kid: kid, method: method, verifyKey: pub}
+	if priv != nil {
+		k.signKey = priv
+	}
+
+	return k, nil
+}
+
+// parsePrivateKeyPEM parses a PEM encoded private key matching method.
+func parsePrivateKeyPEM(method jwt.SigningMethod, b []byte) (crypto.Signer, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		k, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return k, nil
+	case *jwt.SigningMethodECDSA:
+		k, err := jwt.ParseECPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return k, nil
+	case *jwt.SigningMethodEd25519:
+		k, err := jwt.ParseEdPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		signer, ok := k.(crypto.Signer)
+		if !ok {
+			return nil, errors.WithStack(ErrKeyTypeMismatch)
+		}
+		return signer, nil
+	default:
+		return nil, errors.WithStack(ErrSigningMethodNotSupported)
+	}
+}
+
+// parsePublicKeyPEM parses a PEM encoded public key matching method.
+func parsePublicKeyPEM(method jwt.SigningMethod, b []byte) (crypto.PublicKey, error) {
+	var (
+		k   crypto.PublicKey
+		err error
+	)
+
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		k, err = jwt.ParseRSAPublicKeyFromPEM(b)
+	case *jwt.SigningMethodECDSA:
+		k, err = jwt.ParseECPublicKeyFromPEM(b)
+	case *jwt.SigningMethodEd25519:
+		k, err = jwt.ParseEdPublicKeyFromPEM(b)
+	default:
+		return nil, errors.WithStack(ErrSigningMethodNotSupported)
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return k, nil
+}
+
+// checkCurve makes sure the public key type and ECDSA curve fit the signing
+// method, so misconfiguration surfaces on Activate instead of on first use.
+func checkCurve(method jwt.SigningMethod, pub crypto.PublicKey) error {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := pub.(*rsa.PublicKey); !ok {
+			return errors.WithStack(ErrKeyTypeMismatch)
+		}
+	case *jwt.SigningMethodECDSA:
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok || k.Curve.Params().BitSize != m.CurveBits {
+			return errors.WithStack(ErrKeyTypeMismatch)
+		}
+	case *jwt.SigningMethodEd25519:
+		if _, ok := pub.(ed25519.PublicKey); !ok {
+			return errors.WithStack(ErrKeyTypeMismatch)
+		}
+	}
+
+	return nil
+}
+
+// publicKeyEqual reports whether a and b are the same public key.
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// publicKeyID derives a stable key id from the SHA-256 digest of the DER
+// encoded public key.
+func publicKeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+// readPEM returns the inline PEM value when set, otherwise the content of
+// file. Both empty yields nil.
+func readPEM(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+
+	if file == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return b, nil
+}
@@ -2,11 +2,12 @@ package auth
 
 import (
 	"context"
-	"github.com/quocdaitrn/golang-kit/constant"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 
+	"github.com/quocdaitrn/golang-kit/constant"
 	kitcontext "github.com/quocdaitrn/golang-kit/context"
 	kiterrors "github.com/quocdaitrn/golang-kit/errors"
 )
@@ -15,37 +16,181 @@ type AuthenticateClient interface {
 	IntrospectToken(ctx context.Context, accessToken string) (sub string, tid string, err error)
 }
 
-func Authenticate(ac AuthenticateClient) endpoint.Middleware {
+// ClaimsIntrospector is implemented by AuthenticateClients which can return
+// all claims of a token. Authenticate prefers it, since revocation checks need
+// the token id and issue time.
+type ClaimsIntrospector interface {
+	IntrospectTokenClaims(ctx context.Context, accessToken string) (claims *Claims, err error)
+}
+
+// AuthenticateOption configures the Authenticate middleware.
+type AuthenticateOption func(*authenticateOptions)
+
+type authenticateOptions struct {
+	revocations RevocationStore
+}
+
+// WithRevocationStore makes Authenticate reject revoked tokens with
+// ErrTokenBlacklisted. The AuthenticateClient must be a ClaimsIntrospector,
+// since revocations are checked against the token id and issue time;
+// Authenticate panics otherwise.
+func WithRevocationStore(rs RevocationStore) AuthenticateOption {
+	return func(o *authenticateOptions) {
+		o.revocations = rs
+	}
+}
+
+func Authenticate(ac AuthenticateClient, opts ...AuthenticateOption) endpoint.Middleware {
+	var o authenticateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if _, ok := ac.(ClaimsIntrospector); o.revocations != nil && !ok {
+		panic("auth: WithRevocationStore requires an AuthenticateClient implementing ClaimsIntrospector")
+	}
+
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (interface{}, error) {
-			token, err := extractTokenFromHeaderString(constant.ContextAuthorization.Get(ctx))
+			token, err := extractToken(ctx)
 			if err != nil {
-				return nil, kiterrors.ErrUnauthorized.WithDetails(err)
+				return nil, err
 			}
 
-			sub, tid, err := ac.IntrospectToken(ctx, token)
+			claims, err := introspect(ctx, ac, token)
 			if err != nil {
-				return nil, kiterrors.ErrUnauthorized.WithDetails(err)
+				return nil, authError(err)
 			}
 
-			uid := kitcontext.UID{
-				Sub: sub,
-				Tid: tid,
+			if o.revocations != nil {
+				if err := checkRevocation(ctx, o.revocations, claims); err != nil {
+					return nil, err
+				}
 			}
-			ctx = kitcontext.WithUID(ctx, uid)
+
+			ctx = kitcontext.WithPrincipal(ctx, claims.Principal())
 			return next(ctx, request)
 		}
 	}
 }
 
-func extractTokenFromHeaderString(s string) (string, error) {
-	parts := strings.Split(s, " ") //"Authorization" : "Bearer {token}"
+// introspect returns the claims of token, falling back to IntrospectToken
+// when ac is not a ClaimsIntrospector.
+func introspect(ctx context.Context, ac AuthenticateClient, token string) (*Claims, error) {
+	if ci, ok := ac.(ClaimsIntrospector); ok {
+		return ci.IntrospectTokenClaims(ctx, token)
+	}
+
+	sub, tid, err := ac.IntrospectToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{Tid: tid}
+	claims.Subject = sub
+
+	return claims, nil
+}
+
+// checkRevocation reports ErrTokenBlacklisted for revoked tokens.
+func checkRevocation(ctx context.Context, rs RevocationStore, claims *Claims) error {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	revoked, err := rs.IsRevoked(ctx, claims.ID, claims.Subject, issuedAt)
+	if err != nil {
+		return err
+	}
+
+	if revoked {
+		return kiterrors.WithStack(kiterrors.ErrTokenBlacklisted)
+	}
+
+	return nil
+}
+
+// extractToken returns the access token of the request, found in the
+// credentials populated by http.PopulateRequestCredentials or in the
+// "Authorization" header populated by http.PopulateRequestAuthorizationToken.
+// A request carrying no token fails with ErrAuthorizationHeaderMissing, more
+// than one with ErrMultipleTokenProvied and an "Authorization" header of
+// another scheme than Bearer with ErrBearerTokenInvalid.
+func extractToken(ctx context.Context) (string, error) {
+	creds := kitcontext.CredentialsFromContext(ctx)
+
+	if h := constant.ContextAuthorization.Get(ctx); h != "" && !hasAuthorizationHeader(creds) {
+		creds = append(creds, kitcontext.Credential{
+			Source: kitcontext.CredentialSourceHeader,
+			Name:   "Authorization",
+			Value:  h,
+		})
+	}
+
+	var tokens []string
+	for _, c := range creds {
+		token := c.Value
+		if isAuthorizationHeader(c) {
+			var err error
+			if token, err = extractBearerToken(c.Value); err != nil {
+				return "", err
+			}
+		}
+
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	switch len(tokens) {
+	case 0:
+		return "", kiterrors.ErrAuthorizationHeaderMissing.WithDetails("missing access token")
+	case 1:
+		return tokens[0], nil
+	default:
+		return "", kiterrors.WithStack(kiterrors.ErrMultipleTokenProvied)
+	}
+}
+
+// extractBearerToken parses an "Authorization: Bearer {token}" header value,
+// the scheme being case-insensitive.
+func extractBearerToken(s string) (string, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", kiterrors.ErrBearerTokenInvalid.WithDetails("authorization scheme must be Bearer")
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return "", kiterrors.ErrBearerTokenInvalid.WithDetails("malformed bearer token")
+	}
+
+	return token, nil
+}
+
+func isAuthorizationHeader(c kitcontext.Credential) bool {
+	return c.Source == kitcontext.CredentialSourceHeader && strings.EqualFold(c.Name, "Authorization")
+}
+
+func hasAuthorizationHeader(creds []kitcontext.Credential) bool {
+	for _, c := range creds {
+		if isAuthorizationHeader(c) {
+			return true
+		}
+	}
+
+	return false
+}
 
-	if parts[0] != "Bearer" || len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
-		return "", kiterrors.ErrUnauthorized.WithDetails("missing access token")
+// authError keeps kit errors reported by an AuthenticateClient, such as
+// ErrTokenExpired, and wraps any other error into ErrUnauthorized.
+func authError(err error) error {
+	if _, ok := kiterrors.Cause(err).(*kiterrors.Error); ok {
+		return err
 	}
 
-	return parts[1], nil
+	return kiterrors.ErrUnauthorized.WithDetails(err)
 }
 
 type errorUnauthorized struct {
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	"github.com/quocdaitrn/golang-kit/internal/strutil"
+)
+
+// ClientCertRule maps client certificates to an identity. A certificate
+// matches when it matches every non empty criterion.
+//
+// Sub and Tid are templates where {cn}, {dns}, {email}, {uri},
+// {spiffe.td} and {spiffe.path} are replaced with the common name, the
+// matched DNS, email and URI SANs, and the trust domain and path of the
+// SPIFFE ID. Sub defaults to the SPIFFE ID if any, else the common name.
+type ClientCertRule struct {
+	// SPIFFEID matches the SPIFFE ID of the certificate, "*" matching one
+	// path segment as in "spiffe://example.org/ns/*/sa/*".
+	SPIFFEID string `json:"spiffe_id,omitempty"`
+
+	// URIPrefix matches a URI SAN starting with it.
+	URIPrefix string `json:"uri_prefix,omitempty"`
+
+	// DNSName matches a DNS SAN, "*." matching one label as in
+	// "*.svc.example.org".
+	DNSName string `json:"dns_name,omitempty"`
+
+	// Email matches an email SAN, or its domain when starting with "@".
+	Email string `json:"email,omitempty"`
+
+	// CommonName and Organization match the subject.
+	CommonName   string `json:"common_name,omitempty"`
+	Organization string `json:"organization,omitempty"`
+
+	Sub    string   `json:"sub,omitempty"`
+	Tid    string   `json:"tid,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// ClientCertAuthenticator maps verified client certificates to identities
+// with the first matching rule.
+type ClientCertAuthenticator struct {
+	rules []ClientCertRule
+}
+
+// NewClientCertAuthenticator creates a ClientCertAuthenticator with rules.
+func NewClientCertAuthenticator(rules ...ClientCertRule) *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{rules: rules}
+}
+
+// Identify returns the claims of cert, with ErrNoCredentialsMatch when no
+// rule matches. cert must have been verified, as done by
+// http.PopulateRequestClientCertificate.
+func (a *ClientCertAuthenticator) Identify(cert *x509.Certificate) (*Claims, error) {
+	for _, rule := range a.rules {
+		vars, ok := rule.match(cert)
+		if !ok {
+			continue
+		}
+
+		sub := rule.Sub
+		if sub == "" {
+			sub = "{cn}"
+			if vars["{spiffe.td}"] != "" {
+				sub = "spiffe://{spiffe.td}{spiffe.path}"
+			}
+		}
+
+		c := &Claims{
+			Tid:    expandCertVars(rule.Tid, vars),
+			Roles:  rule.Roles,
+			Scopes: rule.Scopes,
+		}
+		c.Subject = expandCertVars(sub, vars)
+
+		if c.Subject == "" {
+			continue
+		}
+
+		return c, nil
+	}
+
+	return nil, kiterrors.ErrNoCredentialsMatch.WithDetails("client certificate matches no rule")
+}
+
+// AuthenticateClientCert returns a middleware authenticating the client
+// certificate placed in the context by http.PopulateRequestClientCertificate.
+// The identity is added to the context like Authenticate does.
+func AuthenticateClientCert(a *ClientCertAuthenticator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			cert := kitcontext.ClientCertificateFromContext(ctx)
+			if cert == nil {
+				return nil, kiterrors.ErrUnauthorized.WithDetails("missing client certificate")
+			}
+
+			claims, err := a.Identify(cert)
+			if err != nil {
+				return nil, err
+			}
+
+			p := claims.Principal()
+			p.AuthMethod = kitcontext.AuthMethodClientCert
+			p.TokenID = cert.SerialNumber.String()
+			p.AuthTime = time.Now()
+			ctx = kitcontext.WithPrincipal(ctx, p)
+			return next(ctx, request)
+		}
+	}
+}
+
+// match matches cert and returns the template variables.
+func (r ClientCertRule) match(cert *x509.Certificate) (map[string]string, bool) {
+	vars := map[string]string{
+		"{cn}":          cert.Subject.CommonName,
+		"{dns}":         "",
+		"{email}":       "",
+		"{uri}":         "",
+		"{spiffe.td}":   "",
+		"{spiffe.path}": "",
+	}
+
+	if id := spiffeID(cert); id != nil {
+		vars["{spiffe.td}"] = id.Host
+		vars["{spiffe.path}"] = id.EscapedPath()
+	}
+
+	if r.SPIFFEID != "" {
+		if vars["{spiffe.td}"] == "" || !matchSPIFFEID(r.SPIFFEID, "spiffe://"+vars["{spiffe.td}"]+vars["{spiffe.path}"]) {
+			return nil, false
+		}
+	}
+
+	if r.CommonName != "" && r.CommonName != cert.Subject.CommonName {
+		return nil, false
+	}
+
+	if r.Organization != "" && !strutil.Contains(cert.Subject.Organization, r.Organization) {
+		return nil, false
+	}
+
+	uris := make([]string, 0, len(cert.URIs))
+	for _, u := range cert.URIs {
+		uris = append(uris, u.String())
+	}
+
+	if !matchFirst(uris, r.URIPrefix, "{uri}", vars, func(uri string) bool {
+		return strings.HasPrefix(uri, r.URIPrefix)
+	}) {
+		return nil, false
+	}
+
+	if !matchFirst(cert.DNSNames, r.DNSName, "{dns}", vars, func(name string) bool {
+		return matchDNSName(r.DNSName, name)
+	}) {
+		return nil, false
+	}
+
+	if !matchFirst(cert.EmailAddresses, r.Email, "{email}", vars, func(email string) bool {
+		if strings.HasPrefix(r.Email, "@") {
+			return strings.HasSuffix(strings.ToLower(email), strings.ToLower(r.Email))
+		}
+		return strings.EqualFold(email, r.Email)
+	}) {
+		return nil, false
+	}
+
+	return vars, true
+}
+
+// matchFirst sets vars[name] to the first of values matching match. With an
+// empty criterion it only sets the first value and always matches.
+func matchFirst(values []string, criterion, name string, vars map[string]string, match func(string) bool) bool {
+	for _, v := range values {
+		if criterion == "" || match(v) {
+			vars[name] = v
+			return true
+		}
+	}
+
+	return criterion == ""
+}
+
+// spiffeID returns the SPIFFE ID of cert, which must be its only URI SAN.
+func spiffeID(cert *x509.Certificate) *url.URL {
+	if len(cert.URIs) != 1 || cert.URIs[0].Scheme != "spiffe" || cert.URIs[0].Host == "" {
+		return nil
+	}
+
+	return cert.URIs[0]
+}
+
+// matchSPIFFEID matches id against pattern segment by segment.
+func matchSPIFFEID(pattern, id string) bool {
+	ps := strings.Split(strings.TrimPrefix(pattern, "spiffe://"), "/")
+	is := strings.Split(strings.TrimPrefix(id, "spiffe://"), "/")
+
+	if len(ps) != len(is) {
+		return false
+	}
+
+	for i := range ps {
+		if ps[i] != "*" && ps[i] != is[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchDNSName matches name against pattern, a leading "*." matching one
+// label.
+func matchDNSName(pattern, name string) bool {
+	pattern, name = strings.ToLower(pattern), strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+		label, rest, ok := strings.Cut(name, ".")
+		return ok && label != "" && "."+rest == suffix
+	}
+
+	return pattern == name
+}
+
+func expandCertVars(tmpl string, vars map[string]string) string {
+	if tmpl == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, 2*len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, k, v)
+	}
+
+	return strings.NewReplacer(pairs...).Replace(tmpl)
+}
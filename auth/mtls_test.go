@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kithttp "github.com/quocdaitrn/golang-kit/http"
+)
+
+// testCA is a certificate authority issuing certificates to files of a test
+// directory.
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	ca := &testCA{t: t, dir: t.TempDir()}
+	ca.cert, ca.key = ca.issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	ca.file, _ = ca.write(name, ca.cert, ca.key)
+
+	return ca
+}
+
+// issue signs tmpl with parent, self-signing when parent is nil.
+func (ca *testCA) issue(tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	ca.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// leaf issues a certificate for tmpl and returns its certificate and key
+// files.
+func (ca *testCA) leaf(name string, tmpl *x509.Certificate) (certFile, keyFile string) {
+	ca.t.Helper()
+
+	cert, key := ca.issue(tmpl, ca.cert, ca.key)
+
+	return ca.write(name, cert, key)
+}
+
+func (ca *testCA) write(name string, cert *x509.Certificate, key *ecdsa.PrivateKey) (certFile, keyFile string) {
+	ca.t.Helper()
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	certFile = filepath.Join(ca.dir, name+".pem")
+	keyFile = filepath.Join(ca.dir, name+".key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		ca.t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		ca.t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+// newMTLSServer starts a TLS server requiring client certificates of
+// clientCA, which answers with the principal identified by a.
+func newMTLSServer(t *testing.T, serverCA, clientCA *testCA, a *ClientCertAuthenticator) *httptest.Server {
+	t.Helper()
+
+	certFile, keyFile := serverCA.leaf("server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	cfg, err := kithttp.NewServerTLSConfig(certFile, keyFile, clientCA.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep := AuthenticateClientCert(a)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return kitcontext.PrincipalFromContext(ctx), nil
+	})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := ep(kithttp.PopulateRequestClientCertificate(r.Context(), r), nil)
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(p)
+	}))
+	srv.TLS = cfg
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newMTLSClient creates a client presenting a certificate of tmpl issued by
+// ca and trusting rootCA.
+func newMTLSClient(t *testing.T, ca, rootCA *testCA, tmpl *x509.Certificate) *http.Client {
+	t.Helper()
+
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	certFile, keyFile := ca.leaf("client", tmpl)
+
+	cfg, err := kithttp.NewClientTLSConfig(certFile, keyFile, rootCA.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+// localhostURL returns the URL of srv with the localhost name, the one of the
+// server certificate.
+func localhostURL(srv *httptest.Server) string {
+	u, _ := url.Parse(srv.URL)
+	u.Host = net.JoinHostPort("localhost", u.Port())
+	return u.String()
+}
+
+func TestClientCertAuthenticationOverTLS(t *testing.T) {
+	ca := newTestCA(t, "ca")
+
+	a := NewClientCertAuthenticator(
+		ClientCertRule{SPIFFEID: "spiffe://prod.acme/ns/*/sa/*", Tid: "{spiffe.td}", Roles: []string{"service"}},
+		ClientCertRule{DNSName: "*.svc.acme.test", Sub: "svc:{dns}"},
+		ClientCertRule{Email: "@acme.test", CommonName: "ops", Sub: "{email}"},
+	)
+
+	srv := newMTLSServer(t, ca, ca, a)
+
+	spiffe, _ := url.Parse("spiffe://prod.acme/ns/billing/sa/api")
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		wantSub  string
+		wantTid  string
+		wantCode int
+	}{
+		{
+			name:     "SPIFFE ID",
+			cert:     &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{spiffe}},
+			wantSub:  "spiffe://prod.acme/ns/billing/sa/api",
+			wantTid:  "prod.acme",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "DNS SAN",
+			cert:     &x509.Certificate{Subject: pkix.Name{CommonName: "orders"}, DNSNames: []string{"orders.svc.acme.test"}},
+			wantSub:  "svc:orders.svc.acme.test",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "email SAN",
+			cert:     &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}, EmailAddresses: []string{"oncall@acme.test"}},
+			wantSub:  "oncall@acme.test",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "nested DNS SAN",
+			cert:     &x509.Certificate{Subject: pkix.Name{CommonName: "orders"}, DNSNames: []string{"a.orders.svc.acme.test"}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "other trust domain",
+			cert:     &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{{Scheme: "spiffe", Host: "dev.acme", Path: "/ns/billing/sa/api"}}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newMTLSClient(t, ca, ca, tt.cert).Get(localhostURL(srv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var p kitcontext.Principal
+			if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Sub != tt.wantSub || p.Tid != tt.wantTid || p.AuthMethod != kitcontext.AuthMethodClientCert {
+				t.Errorf("principal = %+v, want sub %q and tid %q", p, tt.wantSub, tt.wantTid)
+			}
+		})
+	}
+}
+
+func TestClientCertAuthenticationRejectsUntrustedPeers(t *testing.T) {
+	ca, other := newTestCA(t, "ca"), newTestCA(t, "other")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+
+	srv := newMTLSServer(t, ca, ca, NewClientCertAuthenticator(ClientCertRule{CommonName: "billing"}))
+
+	if _, err := newMTLSClient(t, other, ca, cert).Get(localhostURL(srv)); err == nil {
+		t.Error("client certificate of an untrusted CA accepted")
+	}
+
+	if _, err := newMTLSClient(t, ca, other, cert).Get(localhostURL(srv)); err == nil {
+		t.Error("server certificate of an untrusted CA accepted")
+	}
+
+	resp, err := newMTLSClient(t, ca, ca, cert).Get(localhostURL(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+
+	// The server certificate is verified against the requested name.
+	client := newMTLSClient(t, ca, ca, cert)
+	client.Transport.(*http.Transport).TLSClientConfig.ServerName = "other.test"
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Error("server certificate accepted for another name")
+	}
+}
@@ -0,0 +1,386 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	"github.com/quocdaitrn/golang-kit/internal/sweep"
+)
+
+// Hash algorithms of one-time passwords.
+const (
+	OTPAlgorithmSHA1   = "SHA1"
+	OTPAlgorithmSHA256 = "SHA256"
+	OTPAlgorithmSHA512 = "SHA512"
+)
+
+const (
+	defaultOTPDigits       = 6
+	defaultTOTPPeriod      = 30 * time.Second
+	defaultTOTPSkew        = 1
+	defaultOTPSecretLength = 20
+
+	recoveryCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrOTPSecretInvalid    = kiterrors.New("invalid one-time password secret")
+	ErrOTPAlgorithmUnknown = kiterrors.New("unknown one-time password algorithm")
+)
+
+var otpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewOTPSecret generates a random 160-bit secret encoded in unpadded base32,
+// the format authenticator apps expect.
+func NewOTPSecret() (string, error) {
+	b, err := randomBytes(defaultOTPSecretLength)
+	if err != nil {
+		return "", err
+	}
+
+	return otpEncoding.EncodeToString(b), nil
+}
+
+// decodeOTPSecret decodes a base32 secret, ignoring case, spaces and padding.
+func decodeOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+
+	key, err := otpEncoding.DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, kiterrors.WithStack(ErrOTPSecretInvalid)
+	}
+
+	return key, nil
+}
+
+// HOTP generates and verifies counter based one-time passwords (RFC 4226).
+type HOTP struct {
+	// Digits is the code length, 6 when zero.
+	Digits int
+
+	// Algorithm is the HMAC hash, OTPAlgorithmSHA1 when empty.
+	Algorithm string
+
+	// LookAhead is the number of counters after the expected one accepted
+	// by Verify, to resynchronize with tokens whose counter moved ahead.
+	LookAhead int
+}
+
+// Generate returns the code of the base32 secret at counter.
+func (h *HOTP) Generate(secret string, counter uint64) (string, error) {
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return otpCode(key, counter, h.Digits, h.Algorithm)
+}
+
+// Verify verifies code against the counters from counter to counter plus
+// LookAhead. On success it returns the counter to use for the next
+// verification, which the caller must store.
+func (h *HOTP) Verify(secret, code string, counter uint64) (next uint64, ok bool, err error) {
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return counter, false, err
+	}
+
+	for c := counter; c <= counter+uint64(h.LookAhead); c++ {
+		expected, err := otpCode(key, c, h.Digits, h.Algorithm)
+		if err != nil {
+			return counter, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return c + 1, true, nil
+		}
+	}
+
+	return counter, false, nil
+}
+
+// OTPReplayStore records the last time step used by each account so that a
+// code can't be used twice.
+type OTPReplayStore interface {
+	// Use records counter as used for key and reports false if counter or a
+	// later one has already been used. The record is kept until expiresAt.
+	Use(ctx context.Context, key string, counter uint64, expiresAt time.Time) (bool, error)
+}
+
+// TOTP generates and verifies time based one-time passwords (RFC 6238).
+type TOTP struct {
+	// Issuer is the service name shown by authenticator apps.
+	Issuer string
+
+	// Digits is the code length, 6 when zero.
+	Digits int
+
+	// Period is the time step, 30 seconds when zero.
+	Period time.Duration
+
+	// Skew is the number of time steps before and after the current one
+	// accepted by Verify, 1 when zero. A negative value accepts only the
+	// current step.
+	Skew int
+
+	// Algorithm is the HMAC hash, OTPAlgorithmSHA1 when empty. Many
+	// authenticator apps only support SHA1.
+	Algorithm string
+
+	// Replays rejects codes of an account whose time step was already used
+	// when set.
+	Replays OTPReplayStore
+}
+
+// ProvisioningURI returns the otpauth:// URI registering secret for account
+// in authenticator apps, usually shown as a QR code.
+func (t *TOTP) ProvisioningURI(secret, account string) string {
+	label := url.PathEscape(account)
+	if t.Issuer != "" {
+		label = url.PathEscape(t.Issuer) + ":" + label
+	}
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	if t.Issuer != "" {
+		q.Set("issuer", t.Issuer)
+	}
+	q.Set("algorithm", t.algorithm())
+	q.Set("digits", strconv.Itoa(t.digits()))
+	q.Set("period", strconv.Itoa(int(t.period()/time.Second)))
+
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Generate returns the code of secret at time at.
+func (t *TOTP) Generate(secret string, at time.Time) (string, error) {
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return otpCode(key, t.counter(at), t.Digits, t.Algorithm)
+}
+
+// Verify verifies the code of account at the current time. With Replays set,
+// a code whose time step or a later one was already used is rejected.
+func (t *TOTP) Verify(ctx context.Context, secret, account, code string) (bool, error) {
+	return t.VerifyAt(ctx, secret, account, code, time.Now())
+}
+
+// VerifyAt verifies the code of account at time at.
+func (t *TOTP) VerifyAt(ctx context.Context, secret, account, code string, at time.Time) (bool, error) {
+	key, err := decodeOTPSecret(secret)
+	if err != nil {
+		return false, err
+	}
+
+	skew := t.Skew
+	switch {
+	case skew == 0:
+		skew = defaultTOTPSkew
+	case skew < 0:
+		skew = 0
+	}
+
+	now := t.counter(at)
+	for i := -skew; i <= skew; i++ {
+		if i < 0 && uint64(-i) > now {
+			continue
+		}
+		c := now + uint64(i)
+
+		expected, err := otpCode(key, c, t.Digits, t.Algorithm)
+		if err != nil {
+			return false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		if t.Replays == nil {
+			return true, nil
+		}
+
+		expiresAt := at.Add(time.Duration(skew+1) * t.period())
+		return t.Replays.Use(ctx, t.Issuer+":"+account, c, expiresAt)
+	}
+
+	return false, nil
+}
+
+func (t *TOTP) counter(at time.Time) uint64 {
+	return uint64(at.Unix() / int64(t.period()/time.Second))
+}
+
+func (t *TOTP) period() time.Duration {
+	if t.Period < time.Second {
+		return defaultTOTPPeriod
+	}
+
+	return t.Period
+}
+
+func (t *TOTP) digits() int {
+	if t.Digits == 0 {
+		return defaultOTPDigits
+	}
+
+	return t.Digits
+}
+
+func (t *TOTP) algorithm() string {
+	if t.Algorithm == "" {
+		return OTPAlgorithmSHA1
+	}
+
+	return t.Algorithm
+}
+
+// otpCode computes the HOTP value of key at counter.
+func otpCode(key []byte, counter uint64, digits int, algorithm string) (string, error) {
+	if digits == 0 {
+		digits = defaultOTPDigits
+	}
+	if digits < 6 || digits > 10 {
+		return "", kiterrors.Errorf("one-time password must have 6 to 10 digits, got %d", digits)
+	}
+
+	var h func() hash.Hash
+	switch algorithm {
+	case "", OTPAlgorithmSHA1:
+		h = sha1.New
+	case OTPAlgorithmSHA256:
+		h = sha256.New
+	case OTPAlgorithmSHA512:
+		h = sha512.New
+	default:
+		return "", kiterrors.Errorf("%s: %q", ErrOTPAlgorithmUnknown, algorithm)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(h, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3.
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
+
+// memoryOTPReplayStore is an in-memory OTPReplayStore.
+type memoryOTPReplayStore struct {
+	mu      sync.Mutex
+	entries map[string]otpReplayEntry
+	sweeps  sweep.Schedule
+}
+
+type otpReplayEntry struct {
+	counter   uint64
+	expiresAt time.Time
+}
+
+// NewMemoryOTPReplayStore creates an OTPReplayStore kept in memory. It suits
+// a single instance, replicated services need a shared store.
+func NewMemoryOTPReplayStore() OTPReplayStore {
+	return &memoryOTPReplayStore{entries: map[string]otpReplayEntry{}}
+}
+
+// Use implements OTPReplayStore.
+func (s *memoryOTPReplayStore) Use(_ context.Context, key string, counter uint64, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.sweeps.Due(now) {
+		for k, e := range s.entries {
+			if now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	if e, ok := s.entries[key]; ok && !now.After(e.expiresAt) && counter <= e.counter {
+		return false, nil
+	}
+
+	s.entries[key] = otpReplayEntry{counter: counter, expiresAt: expiresAt}
+
+	return true, nil
+}
+
+// GenerateRecoveryCodes generates n one-time recovery codes formatted as
+// XXXXX-XXXXX, and their hashes. The codes are shown once to the user and
+// only the hashes are stored.
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b, err := randomBytes(10)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := make([]byte, 0, 11)
+		for j, c := range b {
+			if j == 5 {
+				code = append(code, '-')
+			}
+			code = append(code, recoveryCodeAlphabet[int(c)%len(recoveryCodeAlphabet)])
+		}
+
+		codes = append(codes, string(code))
+		hashes = append(hashes, HashRecoveryCode(string(code)))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes.
+// Recovery codes are random enough for a fast hash.
+func HashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyRecoveryCode returns the index of the hash matching code in hashes.
+// The caller must remove the matching hash so that the code can't be used
+// again.
+func VerifyRecoveryCode(code string, hashes []string) (index int, ok bool) {
+	h := []byte(HashRecoveryCode(code))
+
+	index = -1
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare(h, []byte(stored)) == 1 && index < 0 {
+			index = i
+		}
+	}
+
+	return index, index >= 0
+}
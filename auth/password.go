@@ -0,0 +1,460 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/quocdaitrn/golang-kit/errors"
+)
+
+// Identifiers of the supported password hashing algorithms.
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmScrypt   = "scrypt"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
+// Bounds of the parameters accepted when verifying, so that a tampered hash
+// can't make verification crash or exhaust the server.
+const (
+	argon2MaxMemory     = 4 * 1024 * 1024 // 4 GiB, in KiB
+	argon2MaxIterations = 100
+
+	scryptMaxLogN   = 24
+	scryptMaxR      = 64
+	scryptMaxP      = 16
+	scryptMaxMemory = 4 << 30 // 4 GiB, in bytes
+
+	bcryptMaxCost = 16
+)
+
+var (
+	ErrPHCInvalid               = errors.New("invalid PHC string")
+	ErrPasswordAlgorithmUnknown = errors.New("unknown password hashing algorithm")
+)
+
+// PHC is a hash in the PHC string format:
+//
+//	$<id>[$v=<version>][$<param>=<value>(,<param>=<value>)*][$<salt>[$<hash>]]
+//
+// Salt and Hash are kept encoded, in unpadded standard base64 for all the
+// algorithms of the kit except bcrypt which uses its own alphabet.
+type PHC struct {
+	ID      string
+	Version string
+	Params  []PHCParam
+	Salt    string
+	Hash    string
+}
+
+// PHCParam is a parameter of a PHC string.
+type PHCParam struct {
+	Key   string
+	Value string
+}
+
+// ParsePHC parses a PHC string.
+func ParsePHC(s string) (*PHC, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, errors.WithStack(ErrPHCInvalid)
+	}
+
+	fields := strings.Split(s[1:], "$")
+	if fields[0] == "" {
+		return nil, errors.WithStack(ErrPHCInvalid)
+	}
+
+	h := &PHC{ID: fields[0]}
+	fields = fields[1:]
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		h.Version = strings.TrimPrefix(fields[0], "v=")
+		fields = fields[1:]
+	}
+
+	if len(fields) > 0 && strings.Contains(fields[0], "=") {
+		for _, kv := range strings.Split(fields[0], ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" {
+				return nil, errors.WithStack(ErrPHCInvalid)
+			}
+			h.Params = append(h.Params, PHCParam{Key: k, Value: v})
+		}
+		fields = fields[1:]
+	}
+
+	switch len(fields) {
+	case 0:
+	case 1:
+		h.Salt = fields[0]
+	case 2:
+		h.Salt, h.Hash = fields[0], fields[1]
+	default:
+		return nil, errors.WithStack(ErrPHCInvalid)
+	}
+
+	return h, nil
+}
+
+// String encodes the hash in the PHC string format.
+func (h *PHC) String() string {
+	var b strings.Builder
+
+	b.WriteString("$" + h.ID)
+
+	if h.Version != "" {
+		b.WriteString("$v=" + h.Version)
+	}
+
+	if len(h.Params) > 0 {
+		params := make([]string, 0, len(h.Params))
+		for _, p := range h.Params {
+			params = append(params, p.Key+"="+p.Value)
+		}
+		b.WriteString("$" + strings.Join(params, ","))
+	}
+
+	if h.Salt != "" {
+		b.WriteString("$" + h.Salt)
+		if h.Hash != "" {
+			b.WriteString("$" + h.Hash)
+		}
+	}
+
+	return b.String()
+}
+
+// Param returns the value of the parameter key.
+func (h *PHC) Param(key string) (string, bool) {
+	for _, p := range h.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// intParam returns the integer value of the parameter key.
+func (h *PHC) intParam(key string) (int, error) {
+	v, ok := h.Param(key)
+	if !ok {
+		return 0, errors.Errorf("%s: missing parameter %s", ErrPHCInvalid, key)
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("%s: invalid parameter %s", ErrPHCInvalid, key)
+	}
+
+	return n, nil
+}
+
+// PasswordAlgorithm is a password hashing algorithm.
+type PasswordAlgorithm interface {
+	// ID returns the PHC identifier of the algorithm.
+	ID() string
+
+	// Hash hashes password with a random salt.
+	Hash(password []byte) (*PHC, error)
+
+	// Verify reports whether password matches the hash h.
+	Verify(h *PHC, password []byte) (bool, error)
+
+	// NeedsRehash reports whether h was produced with parameters other than
+	// the current ones.
+	NeedsRehash(h *PHC) bool
+}
+
+// Argon2id hashes passwords with Argon2id. Memory is in KiB.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id returns Argon2id with the parameters used by default.
+func DefaultArgon2id() *Argon2id {
+	return &Argon2id{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (a *Argon2id) ID() string {
+	return PasswordAlgorithmArgon2id
+}
+
+func (a *Argon2id) Hash(password []byte) (*PHC, error) {
+	salt, err := randomBytes(int(a.SaltLength))
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey(password, salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return &PHC{
+		ID:      a.ID(),
+		Version: strconv.Itoa(argon2.Version),
+		Params: []PHCParam{
+			{Key: "m", Value: strconv.FormatUint(uint64(a.Memory), 10)},
+			{Key: "t", Value: strconv.FormatUint(uint64(a.Iterations), 10)},
+			{Key: "p", Value: strconv.FormatUint(uint64(a.Parallelism), 10)},
+		},
+		Salt: base64.RawStdEncoding.EncodeToString(salt),
+		Hash: base64.RawStdEncoding.EncodeToString(key),
+	}, nil
+}
+
+func (a *Argon2id) Verify(h *PHC, password []byte) (bool, error) {
+	if h.Version != strconv.Itoa(argon2.Version) {
+		return false, errors.Errorf("%s: unsupported argon2 version %s", ErrPHCInvalid, h.Version)
+	}
+
+	m, err := h.intParam("m")
+	if err != nil {
+		return false, err
+	}
+	t, err := h.intParam("t")
+	if err != nil {
+		return false, err
+	}
+	p, err := h.intParam("p")
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case m == 0 || m > argon2MaxMemory:
+		return false, errors.Errorf("%s: invalid parameter m", ErrPHCInvalid)
+	case t == 0 || t > argon2MaxIterations:
+		return false, errors.Errorf("%s: invalid parameter t", ErrPHCInvalid)
+	case p == 0 || p > 255:
+		return false, errors.Errorf("%s: invalid parameter p", ErrPHCInvalid)
+	}
+
+	salt, key, err := decodeSaltHash(h)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey(password, salt, uint32(t), uint32(m), uint8(p), uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *Argon2id) NeedsRehash(h *PHC) bool {
+	return h.ID != a.ID() ||
+		!paramEquals(h, "m", uint64(a.Memory)) ||
+		!paramEquals(h, "t", uint64(a.Iterations)) ||
+		!paramEquals(h, "p", uint64(a.Parallelism)) ||
+		h.Version != strconv.Itoa(argon2.Version)
+}
+
+// Scrypt hashes passwords with scrypt, N being 2^LogN.
+type Scrypt struct {
+	LogN       uint8
+	R          int
+	P          int
+	SaltLength int
+	KeyLength  int
+}
+
+// DefaultScrypt returns Scrypt with the parameters used by default.
+func DefaultScrypt() *Scrypt {
+	return &Scrypt{
+		LogN:       15,
+		R:          8,
+		P:          1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+func (s *Scrypt) ID() string {
+	return PasswordAlgorithmScrypt
+}
+
+func (s *Scrypt) Hash(password []byte) (*PHC, error) {
+	salt, err := randomBytes(s.SaltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(password, salt, 1<<s.LogN, s.R, s.P, s.KeyLength)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &PHC{
+		ID: s.ID(),
+		Params: []PHCParam{
+			{Key: "ln", Value: strconv.Itoa(int(s.LogN))},
+			{Key: "r", Value: strconv.Itoa(s.R)},
+			{Key: "p", Value: strconv.Itoa(s.P)},
+		},
+		Salt: base64.RawStdEncoding.EncodeToString(salt),
+		Hash: base64.RawStdEncoding.EncodeToString(key),
+	}, nil
+}
+
+func (s *Scrypt) Verify(h *PHC, password []byte) (bool, error) {
+	ln, err := h.intParam("ln")
+	if err != nil {
+		return false, err
+	}
+	r, err := h.intParam("r")
+	if err != nil {
+		return false, err
+	}
+	p, err := h.intParam("p")
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case ln == 0 || ln > scryptMaxLogN:
+		return false, errors.Errorf("%s: invalid parameter ln", ErrPHCInvalid)
+	case r == 0 || r > scryptMaxR:
+		return false, errors.Errorf("%s: invalid parameter r", ErrPHCInvalid)
+	case p == 0 || p > scryptMaxP:
+		return false, errors.Errorf("%s: invalid parameter p", ErrPHCInvalid)
+	case 128*r*(1<<ln)*p > scryptMaxMemory:
+		return false, errors.Errorf("%s: parameters exceed the memory limit", ErrPHCInvalid)
+	}
+
+	salt, key, err := decodeSaltHash(h)
+	if err != nil {
+		return false, err
+	}
+
+	other, err := scrypt.Key(password, salt, 1<<ln, r, p, len(key))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (s *Scrypt) NeedsRehash(h *PHC) bool {
+	return h.ID != s.ID() ||
+		!paramEquals(h, "ln", uint64(s.LogN)) ||
+		!paramEquals(h, "r", uint64(s.R)) ||
+		!paramEquals(h, "p", uint64(s.P))
+}
+
+// Bcrypt hashes passwords with bcrypt. The password is pre-hashed with
+// SHA-256 so passwords longer than the 72 bytes bcrypt reads are not
+// truncated.
+type Bcrypt struct {
+	Cost int
+}
+
+// DefaultBcrypt returns Bcrypt with the cost used by default.
+func DefaultBcrypt() *Bcrypt {
+	return &Bcrypt{Cost: 12}
+}
+
+func (b *Bcrypt) ID() string {
+	return PasswordAlgorithmBcrypt
+}
+
+func (b *Bcrypt) Hash(password []byte) (*PHC, error) {
+	h, err := bcrypt.GenerateFromPassword(bcryptPrehash(password), b.Cost)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// bcrypt yields $2a$<cost>$<22 chars salt><31 chars hash>.
+	enc := string(h)[7:]
+
+	return &PHC{
+		ID:     b.ID(),
+		Params: []PHCParam{{Key: "r", Value: strconv.Itoa(b.Cost)}},
+		Salt:   enc[:22],
+		Hash:   enc[22:],
+	}, nil
+}
+
+func (b *Bcrypt) Verify(h *PHC, password []byte) (bool, error) {
+	cost, err := h.intParam("r")
+	if err != nil {
+		return false, err
+	}
+	if cost < bcrypt.MinCost || cost > bcryptMaxCost {
+		return false, errors.Errorf("%s: invalid parameter r", ErrPHCInvalid)
+	}
+
+	mcf := fmt.Sprintf("$2a$%02d$%s%s", cost, h.Salt, h.Hash)
+	err = bcrypt.CompareHashAndPassword([]byte(mcf), bcryptPrehash(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	default:
+		return false, errors.WithStack(err)
+	}
+}
+
+func (b *Bcrypt) NeedsRehash(h *PHC) bool {
+	return h.ID != b.ID() || !paramEquals(h, "r", uint64(b.Cost))
+}
+
+func bcryptPrehash(password []byte) []byte {
+	sum := sha256.Sum256(password)
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// defaultPasswordAlgorithm returns the algorithm verifying hashes of id when
+// it is not the configured algorithm.
+func defaultPasswordAlgorithm(id string) (PasswordAlgorithm, bool) {
+	switch id {
+	case PasswordAlgorithmArgon2id:
+		return DefaultArgon2id(), true
+	case PasswordAlgorithmScrypt:
+		return DefaultScrypt(), true
+	case PasswordAlgorithmBcrypt:
+		return DefaultBcrypt(), true
+	default:
+		return nil, false
+	}
+}
+
+func decodeSaltHash(h *PHC) (salt, key []byte, err error) {
+	if salt, err = base64.RawStdEncoding.DecodeString(h.Salt); err != nil {
+		return nil, nil, errors.Errorf("%s: %s", ErrPHCInvalid, err)
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(h.Hash); err != nil || len(key) == 0 {
+		return nil, nil, errors.Errorf("%s: invalid hash", ErrPHCInvalid)
+	}
+
+	return salt, key, nil
+}
+
+func paramEquals(h *PHC, key string, want uint64) bool {
+	v, ok := h.Param(key)
+	return ok && v == strconv.FormatUint(want, 10)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// Rules of a password policy, used as keys of the violation details.
+const (
+	PasswordRuleMinLength = "min_length"
+	PasswordRuleMaxLength = "max_length"
+	PasswordRuleUpper     = "upper"
+	PasswordRuleLower     = "lower"
+	PasswordRuleDigit     = "digit"
+	PasswordRuleSymbol    = "symbol"
+	PasswordRuleEntropy   = "entropy"
+	PasswordRuleUserInfo  = "user_info"
+	PasswordRuleBreached  = "breached"
+)
+
+// minUserInputLength is the length under which user inputs are not searched
+// for in passwords, to not reject passwords containing a short name.
+const minUserInputLength = 3
+
+// BreachedPasswordList reports how many times a password appeared in known
+// breaches.
+type BreachedPasswordList interface {
+	Count(password string) (int, error)
+}
+
+// PasswordPolicy is a set of password strength rules. Zero fields disable
+// their rule.
+type PasswordPolicy struct {
+	// MinLength and MaxLength bound the number of characters.
+	MinLength int
+	MaxLength int
+
+	// Require* require at least one character of the class.
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// MinEntropyBits is the minimum estimated entropy, see EstimateEntropy.
+	MinEntropyBits float64
+
+	// ForbidUserInputs rejects passwords containing one of the user inputs
+	// given to Check, such as the username or email.
+	ForbidUserInputs bool
+
+	// Breached rejects passwords found in the list at least BreachedMinCount
+	// times, once when BreachedMinCount is zero.
+	Breached         BreachedPasswordList
+	BreachedMinCount int
+}
+
+// DefaultPasswordPolicy returns a policy following current guidelines: a
+// length of 8 to 128 characters, a minimum entropy and no user inputs, rather
+// than character classes.
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:        8,
+		MaxLength:        128,
+		MinEntropyBits:   40,
+		ForbidUserInputs: true,
+	}
+}
+
+// Check checks password against the policy. Violations are reported as
+// ErrInvalidRequest with the details mapping each violated rule to a message.
+// userInputs are the values password must not contain, such as the username
+// or email.
+func (p *PasswordPolicy) Check(password string, userInputs ...string) error {
+	violations, err := p.Violations(password, userInputs...)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return kiterrors.WithStack(kiterrors.ErrInvalidRequest.WithDetails(violations))
+	}
+
+	return nil
+}
+
+// Violations returns the rules password violates mapped to a message. An error
+// is only returned when the breached password list can't be read.
+func (p *PasswordPolicy) Violations(password string, userInputs ...string) (map[string]string, error) {
+	violations := map[string]string{}
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations[PasswordRuleMinLength] = fmt.Sprintf("must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations[PasswordRuleMaxLength] = fmt.Sprintf("must be at most %d characters long", p.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations[PasswordRuleUpper] = "must contain an uppercase letter"
+	}
+	if p.RequireLower && !lower {
+		violations[PasswordRuleLower] = "must contain a lowercase letter"
+	}
+	if p.RequireDigit && !digit {
+		violations[PasswordRuleDigit] = "must contain a digit"
+	}
+	if p.RequireSymbol && !symbol {
+		violations[PasswordRuleSymbol] = "must contain a symbol"
+	}
+
+	if p.MinEntropyBits > 0 && EstimateEntropy(password) < p.MinEntropyBits {
+		violations[PasswordRuleEntropy] = "is too easy to guess"
+	}
+
+	if p.ForbidUserInputs && containsUserInput(password, userInputs) {
+		violations[PasswordRuleUserInfo] = "must not contain personal information"
+	}
+
+	if p.Breached != nil && password != "" {
+		n, err := p.Breached.Count(password)
+		if err != nil {
+			return nil, err
+		}
+
+		min := p.BreachedMinCount
+		if min < 1 {
+			min = 1
+		}
+		if n >= min {
+			violations[PasswordRuleBreached] = "has appeared in a data breach"
+		}
+	}
+
+	return violations, nil
+}
+
+// EstimateEntropy estimates the entropy of password in bits from the size of
+// the character classes it uses. Characters repeating or continuing a
+// sequence of the previous one, as in "aaa" or "abc", count for half.
+func EstimateEntropy(password string) float64 {
+	var (
+		pool                               int
+		upper, lower, digit, symbol, other bool
+		length                             float64
+		prev                               rune = -1
+	)
+
+	for _, c := range password {
+		switch {
+		case c < utf8.RuneSelf && unicode.IsUpper(c):
+			upper = true
+		case c < utf8.RuneSelf && unicode.IsLower(c):
+			lower = true
+		case c < utf8.RuneSelf && unicode.IsDigit(c):
+			digit = true
+		case c < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+
+		if d := c - prev; d >= -1 && d <= 1 {
+			length += 0.5
+		} else {
+			length++
+		}
+		prev = c
+	}
+
+	if upper {
+		pool += 26
+	}
+	if lower {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return length * math.Log2(float64(pool))
+}
+
+// containsUserInput reports whether password contains one of inputs or, for
+// emails, their local part, ignoring case.
+func containsUserInput(password string, inputs []string) bool {
+	password = strings.ToLower(password)
+
+	for _, in := range inputs {
+		in = strings.ToLower(strings.TrimSpace(in))
+
+		candidates := []string{in}
+		if local, _, ok := strings.Cut(in, "@"); ok {
+			candidates = append(candidates, local)
+		}
+
+		for _, c := range candidates {
+			if utf8.RuneCountInString(c) >= minUserInputLength && strings.Contains(password, c) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// breachedPasswordDir is a BreachedPasswordList read from a directory of
+// range files in the k-anonymity format of the Have I Been Pwned API.
+type breachedPasswordDir struct {
+	dir string
+}
+
+// NewBreachedPasswordDir creates a BreachedPasswordList looking up passwords
+// in dir, so that it works offline. For a password whose SHA-1 hex digest is
+// PREFIX (5 characters) followed by SUFFIX (35 characters), the file PREFIX or
+// PREFIX.txt lists "SUFFIX:COUNT" lines. A missing file means no password of
+// the range was breached.
+func NewBreachedPasswordDir(dir string) BreachedPasswordList {
+	return &breachedPasswordDir{dir: dir}
+}
+
+// Count returns the breach count of password.
+func (b *breachedPasswordDir) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	f, err := os.Open(filepath.Join(b.dir, prefix))
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(b.dir, prefix+".txt"))
+	}
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, kiterrors.WithStack(err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		hash, count, _ := strings.Cut(strings.TrimSpace(s.Text()), ":")
+		if !strings.EqualFold(hash, suffix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 1, nil
+		}
+
+		return n, nil
+	}
+
+	if err := s.Err(); err != nil {
+		return 0, kiterrors.WithStack(err)
+	}
+
+	return 0, nil
+}
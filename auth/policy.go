@@ -0,0 +1,469 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// Effect is the effect of a policy which applies to a request.
+type Effect string
+
+// Effects of policies.
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+var (
+	ErrPolicyNameMissing        = kiterrors.New("policy name must be specified")
+	ErrPolicyEffectInvalid      = kiterrors.New("policy effect must be allow or deny")
+	ErrPolicyOperatorInvalid    = kiterrors.New("unsupported policy condition operator")
+	ErrPolicyConditionAttrEmpty = kiterrors.New("policy condition attribute must be specified")
+)
+
+// Resource is the object an action is performed on.
+type Resource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// PolicyRequest is the input of a policy decision.
+type PolicyRequest struct {
+	Subject  kitcontext.UID `json:"subject"`
+	Roles    []string       `json:"roles,omitempty"`
+	Scopes   []string       `json:"scopes,omitempty"`
+	Action   string         `json:"action"`
+	Resource Resource       `json:"resource"`
+}
+
+// Attr resolves an attribute path of the request: "action", "subject.sub",
+// "subject.tid", "subject.roles", "subject.scopes", "resource.type",
+// "resource.id" or "resource.<attribute>".
+func (r PolicyRequest) Attr(path string) (interface{}, bool) {
+	switch path {
+	case "action":
+		return r.Action, true
+	case "subject.sub":
+		return r.Subject.Sub, true
+	case "subject.tid":
+		return r.Subject.Tid, true
+	case "subject.roles":
+		return r.Roles, true
+	case "subject.scopes":
+		return r.Scopes, true
+	case "resource.type":
+		return r.Resource.Type, true
+	case "resource.id":
+		return r.Resource.ID, true
+	}
+
+	if name := strings.TrimPrefix(path, "resource."); name != path {
+		v, ok := r.Resource.Attributes[name]
+		return v, ok
+	}
+
+	return nil, false
+}
+
+// Condition is a named predicate over a request. The name explains the
+// decision when the condition does not hold.
+type Condition struct {
+	Name  string
+	Match func(req PolicyRequest) bool
+}
+
+// When creates a Condition from a Go predicate.
+func When(name string, match func(req PolicyRequest) bool) Condition {
+	return Condition{Name: name, Match: match}
+}
+
+// Policy applies to requests whose action and resource type it lists, "*"
+// matching any, and whose conditions all hold.
+type Policy struct {
+	Name          string
+	Effect        Effect
+	Actions       []string
+	ResourceTypes []string
+	Conditions    []Condition
+}
+
+// PolicyResult explains how one policy was evaluated.
+type PolicyResult struct {
+	Policy  string `json:"policy"`
+	Effect  Effect `json:"effect"`
+	Matched bool   `json:"matched"`
+
+	// ConditionFailed reports the policy applied to the action and resource but
+	// one of its conditions did not hold.
+	ConditionFailed bool   `json:"conditionFailed,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+// Decision is the outcome of a policy evaluation.
+type Decision struct {
+	Allowed bool `json:"allowed"`
+
+	// Policy is the name of the deciding policy. When no policy matched it
+	// is the first allow policy whose conditions failed, if any.
+	Policy string `json:"policy,omitempty"`
+
+	Reason  string         `json:"reason"`
+	Results []PolicyResult `json:"results,omitempty"`
+}
+
+// PolicyEngine evaluates policies with deny-overrides: any matching deny
+// policy denies, otherwise any matching allow policy allows, otherwise the
+// request is denied.
+type PolicyEngine struct {
+	mu       sync.RWMutex
+	policies []Policy
+}
+
+// NewPolicyEngine creates a PolicyEngine with policies.
+func NewPolicyEngine(policies ...Policy) *PolicyEngine {
+	return &PolicyEngine{policies: policies}
+}
+
+// Add adds policies to the engine.
+func (e *PolicyEngine) Add(policies ...Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.policies = append(e.policies, policies...)
+}
+
+// Evaluate decides req.
+func (e *PolicyEngine) Evaluate(req PolicyRequest) Decision {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var (
+		d       Decision
+		allowBy string
+		denyBy  string
+	)
+
+	for _, p := range e.policies {
+		res := p.evaluate(req)
+		d.Results = append(d.Results, res)
+
+		if !res.Matched {
+			continue
+		}
+
+		switch {
+		case p.Effect == EffectDeny && denyBy == "":
+			denyBy = p.Name
+		case p.Effect == EffectAllow && allowBy == "":
+			allowBy = p.Name
+		}
+	}
+
+	switch {
+	case denyBy != "":
+		d.Policy = denyBy
+		d.Reason = fmt.Sprintf("denied by policy %q", denyBy)
+	case allowBy != "":
+		d.Allowed = true
+		d.Policy = allowBy
+		d.Reason = fmt.Sprintf("allowed by policy %q", allowBy)
+	default:
+		d.Reason = fmt.Sprintf("no policy allows %q on %q", req.Action, req.Resource.Type)
+
+		// Point at the first allow policy which applied but whose conditions
+		// failed, that is usually the rule the caller expected to pass.
+		for i, res := range d.Results {
+			if e.policies[i].Effect == EffectAllow && res.ConditionFailed {
+				d.Policy = res.Policy
+				d.Reason = fmt.Sprintf("%s: policy %q: %s", d.Reason, res.Policy, res.Reason)
+				break
+			}
+		}
+	}
+
+	return d
+}
+
+// evaluate matches the policy against req.
+func (p Policy) evaluate(req PolicyRequest) PolicyResult {
+	res := PolicyResult{Policy: p.Name, Effect: p.Effect}
+
+	if !matchAny(p.Actions, req.Action) {
+		res.Reason = "action does not apply"
+		return res
+	}
+
+	if !matchAny(p.ResourceTypes, req.Resource.Type) {
+		res.Reason = "resource type does not apply"
+		return res
+	}
+
+	for i, c := range p.Conditions {
+		if c.Match == nil || !c.Match(req) {
+			name := c.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			res.ConditionFailed = true
+			res.Reason = fmt.Sprintf("condition %s not met", name)
+			return res
+		}
+	}
+
+	res.Matched = true
+
+	return res
+}
+
+// matchAny reports whether v is in list, "*" matching anything.
+func matchAny(list []string, v string) bool {
+	for _, s := range list {
+		if s == "*" || s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResourceFunc extracts the resource a request acts on.
+type ResourceFunc func(ctx context.Context, request interface{}) (Resource, error)
+
+// Authorize returns a middleware evaluating action on the resource extracted
+// by resource against e. Denied requests fail with ErrForbidden carrying the
+// failing policy and the reason in Details. It must be chained after
+// Authenticate.
+func Authorize(e *PolicyEngine, action string, resource ResourceFunc) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			uid := kitcontext.UIDFromContext(ctx)
+			if uid.IsZero() {
+				return nil, kiterrors.WithStack(kiterrors.ErrUnauthorized)
+			}
+
+			res, err := resource(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			d := e.Evaluate(PolicyRequest{
+				Subject:  uid,
+				Roles:    kitcontext.RolesFromContext(ctx),
+				Scopes:   kitcontext.ScopesFromContext(ctx),
+				Action:   action,
+				Resource: res,
+			})
+			if !d.Allowed {
+				return nil, kiterrors.ErrForbidden.WithDetails(map[string]string{
+					"policy": d.Policy,
+					"reason": d.Reason,
+				})
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// PolicyFile is the format of a policy file:
+//
+//	{
+//	  "policies": [{
+//	    "name": "edit-own-draft-orders",
+//	    "effect": "allow",
+//	    "actions": ["order:edit"],
+//	    "resources": ["order"],
+//	    "conditions": [
+//	      {"attr": "resource.tenant", "op": "eq", "ref": "subject.tid"},
+//	      {"attr": "resource.status", "op": "eq", "value": "draft"}
+//	    ]
+//	  }]
+//	}
+//
+// A condition compares attribute attr with either a literal value or the
+// attribute ref. Operators are eq, ne, in, not_in, contains and exists.
+type PolicyFile struct {
+	Policies []PolicyRule `json:"policies"`
+}
+
+// PolicyRule is a policy declared in a policy file.
+type PolicyRule struct {
+	Name       string          `json:"name"`
+	Effect     Effect          `json:"effect"`
+	Actions    []string        `json:"actions"`
+	Resources  []string        `json:"resources"`
+	Conditions []RuleCondition `json:"conditions,omitempty"`
+}
+
+// RuleCondition is a condition declared in a policy file.
+type RuleCondition struct {
+	Attr  string      `json:"attr"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+	Ref   string      `json:"ref,omitempty"`
+}
+
+// LoadPolicyFile reads policies from a JSON policy file.
+func LoadPolicyFile(file string) ([]Policy, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, kiterrors.WithStack(err)
+	}
+	defer f.Close()
+
+	return LoadPolicies(f)
+}
+
+// LoadPolicies reads policies in the PolicyFile format from r.
+func LoadPolicies(r io.Reader) ([]Policy, error) {
+	var pf PolicyFile
+	if err := json.NewDecoder(r).Decode(&pf); err != nil {
+		return nil, kiterrors.WithStack(err)
+	}
+
+	policies := make([]Policy, 0, len(pf.Policies))
+	for _, rule := range pf.Policies {
+		p, err := rule.Policy()
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	return policies, nil
+}
+
+// Policy compiles the rule.
+func (r PolicyRule) Policy() (Policy, error) {
+	if r.Name == "" {
+		return Policy{}, kiterrors.WithStack(ErrPolicyNameMissing)
+	}
+
+	if r.Effect != EffectAllow && r.Effect != EffectDeny {
+		return Policy{}, kiterrors.Errorf("%s: policy %q", ErrPolicyEffectInvalid, r.Name)
+	}
+
+	p := Policy{
+		Name:          r.Name,
+		Effect:        r.Effect,
+		Actions:       r.Actions,
+		ResourceTypes: r.Resources,
+	}
+
+	for _, rc := range r.Conditions {
+		c, err := rc.condition()
+		if err != nil {
+			return Policy{}, kiterrors.Errorf("policy %q: %s", r.Name, err)
+		}
+		p.Conditions = append(p.Conditions, c)
+	}
+
+	return p, nil
+}
+
+// condition compiles the rule condition.
+func (rc RuleCondition) condition() (Condition, error) {
+	if rc.Attr == "" {
+		return Condition{}, kiterrors.WithStack(ErrPolicyConditionAttrEmpty)
+	}
+
+	operand := func(req PolicyRequest) (interface{}, bool) {
+		if rc.Ref != "" {
+			return req.Attr(rc.Ref)
+		}
+		return rc.Value, true
+	}
+
+	desc := fmt.Sprintf("%s %s %v", rc.Attr, rc.Op, rc.Value)
+	if rc.Ref != "" {
+		desc = fmt.Sprintf("%s %s %s", rc.Attr, rc.Op, rc.Ref)
+	}
+
+	var match func(attr, operand interface{}) bool
+	switch rc.Op {
+	case "eq":
+		match = equalValues
+	case "ne":
+		match = func(attr, operand interface{}) bool { return !equalValues(attr, operand) }
+	case "in":
+		match = func(attr, operand interface{}) bool { return containsValue(operand, attr) }
+	case "not_in":
+		match = func(attr, operand interface{}) bool { return !containsValue(operand, attr) }
+	case "contains":
+		match = containsValue
+	case "exists":
+		return When(rc.Attr+" exists", func(req PolicyRequest) bool {
+			_, ok := req.Attr(rc.Attr)
+			return ok
+		}), nil
+	default:
+		return Condition{}, kiterrors.Errorf("%s: %q", ErrPolicyOperatorInvalid, rc.Op)
+	}
+
+	return When(desc, func(req PolicyRequest) bool {
+		attr, ok := req.Attr(rc.Attr)
+		if !ok {
+			return false
+		}
+
+		op, ok := operand(req)
+		if !ok {
+			return false
+		}
+
+		return match(attr, op)
+	}), nil
+}
+
+// equalValues compares attribute values, treating all numbers alike since
+// JSON decodes them as float64.
+func equalValues(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// containsValue reports whether the slice list contains v.
+func containsValue(list, v interface{}) bool {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return false
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		if equalValues(rv.Index(i).Interface(), v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
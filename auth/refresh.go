@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	"github.com/quocdaitrn/golang-kit/internal/sweep"
+)
+
+const (
+	defaultRefreshTokenTTL         = 14 * 24 * time.Hour
+	defaultRefreshTokenMaxLifetime = 90 * 24 * time.Hour
+)
+
+// RefreshToken is the stored state of an opaque refresh token. Only the hash
+// of the token secret is stored.
+type RefreshToken struct {
+	ID       string `json:"id"`
+	FamilyID string `json:"familyId"`
+	Hash     string `json:"hash"`
+
+	// Claims of the access tokens issued with this refresh token. Act is
+	// kept so that refreshing an impersonation token doesn't drop the
+	// actor.
+	Sub      string                 `json:"sub"`
+	Tid      string                 `json:"tid,omitempty"`
+	Audience []string               `json:"aud,omitempty"`
+	Roles    []string               `json:"roles,omitempty"`
+	Scopes   []string               `json:"scopes,omitempty"`
+	Act      *Actor                 `json:"act,omitempty"`
+	Extra    map[string]interface{} `json:"ext,omitempty"`
+
+	IssuedAt        time.Time `json:"issuedAt"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+	FamilyExpiresAt time.Time `json:"familyExpiresAt"`
+	Used            bool      `json:"used"`
+	Revoked         bool      `json:"revoked"`
+	ReplacedByID    string    `json:"replacedById,omitempty"`
+}
+
+// RefreshTokenStore persists refresh tokens.
+type RefreshTokenStore interface {
+	// Create stores a new refresh token.
+	Create(ctx context.Context, t *RefreshToken) error
+
+	// Get returns the token with id, or ErrRepoEntityNotFound.
+	Get(ctx context.Context, id string) (*RefreshToken, error)
+
+	// MarkUsed atomically flags the token as used and replaced by
+	// replacedByID. It reports false when the token was already used, which
+	// means it is being replayed.
+	MarkUsed(ctx context.Context, id, replacedByID string) (bool, error)
+
+	// RevokeFamily revokes every token of the family.
+	RevokeFamily(ctx context.Context, familyID string) error
+}
+
+// TokenPair is an access token with the refresh token to renew it.
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+// RefreshTokenOption configures a RefreshTokenManager.
+type RefreshTokenOption func(*RefreshTokenManager)
+
+// WithRefreshTokenTTL sets the lifetime of each refresh token. Every rotation
+// extends the lifetime of the family up to the max lifetime.
+func WithRefreshTokenTTL(d time.Duration) RefreshTokenOption {
+	return func(m *RefreshTokenManager) {
+		m.ttl = d
+	}
+}
+
+// WithRefreshTokenMaxLifetime sets the absolute lifetime of a token family,
+// after which the user must authenticate again.
+func WithRefreshTokenMaxLifetime(d time.Duration) RefreshTokenOption {
+	return func(m *RefreshTokenManager) {
+		m.maxLifetime = d
+	}
+}
+
+// RefreshTokenManager issues access and refresh token pairs and rotates the
+// refresh token on every use. A refresh token used twice revokes its whole
+// family, since that means it was stolen.
+type RefreshTokenManager struct {
+	provider    ClaimsProvider
+	store       RefreshTokenStore
+	ttl         time.Duration
+	maxLifetime time.Duration
+}
+
+// NewRefreshTokenManager creates a RefreshTokenManager issuing access tokens
+// with p and storing refresh tokens in s.
+func NewRefreshTokenManager(p ClaimsProvider, s RefreshTokenStore, opts ...RefreshTokenOption) *RefreshTokenManager {
+	m := &RefreshTokenManager{
+		provider:    p,
+		store:       s,
+		ttl:         defaultRefreshTokenTTL,
+		maxLifetime: defaultRefreshTokenMaxLifetime,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// IssueTokenPair issues an access token for claims and starts a new refresh
+// token family. The subject, tenant, audience, roles, scopes, actor and
+// extra claims are carried over to the refreshed access tokens.
+func (m *RefreshTokenManager) IssueTokenPair(ctx context.Context, claims *Claims) (*TokenPair, error) {
+	familyID, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	rt := &RefreshToken{
+		FamilyID:        familyID,
+		Sub:             claims.Subject,
+		Tid:             claims.Tid,
+		Audience:        claims.Audience,
+		Roles:           claims.Roles,
+		Scopes:          claims.Scopes,
+		Act:             claims.Act,
+		Extra:           claims.Extra,
+		FamilyExpiresAt: now.Add(m.maxLifetime),
+	}
+
+	return m.issue(ctx, rt, nil, now)
+}
+
+// Refresh exchanges a refresh token for a new token pair. The presented
+// refresh token is consumed; presenting it again revokes the family.
+func (m *RefreshTokenManager) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	rt, err := m.lookup(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if rt.Revoked {
+		return nil, kiterrors.ErrTokenBlacklisted.WithDetails("refresh token has been revoked")
+	}
+
+	if rt.Used {
+		return nil, m.reused(ctx, rt)
+	}
+
+	if !now.Before(rt.ExpiresAt) {
+		return nil, kiterrors.ErrTokenExpired.WithDetails("refresh token has expired")
+	}
+
+	next := &RefreshToken{
+		FamilyID:        rt.FamilyID,
+		Sub:             rt.Sub,
+		Tid:             rt.Tid,
+		Audience:        rt.Audience,
+		Roles:           rt.Roles,
+		Scopes:          rt.Scopes,
+		Act:             rt.Act,
+		Extra:           rt.Extra,
+		FamilyExpiresAt: rt.FamilyExpiresAt,
+	}
+
+	return m.issue(ctx, next, rt, now)
+}
+
+// Revoke revokes the family of refreshToken, which logs out the session it
+// belongs to.
+func (m *RefreshTokenManager) Revoke(ctx context.Context, refreshToken string) error {
+	rt, err := m.lookup(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return m.store.RevokeFamily(ctx, rt.FamilyID)
+}
+
+// reused revokes the family of a replayed refresh token.
+func (m *RefreshTokenManager) reused(ctx context.Context, rt *RefreshToken) error {
+	if err := m.store.RevokeFamily(ctx, rt.FamilyID); err != nil {
+		return err
+	}
+
+	return kiterrors.ErrTokenBlacklisted.WithDetails("refresh token reuse detected, all tokens of the session have been revoked")
+}
+
+// issue signs the access token of the pair, consumes the refresh token it
+// replaces, if any, and stores rt. The access token is signed first so that
+// a signing failure never leaves a consumed refresh token without
+// replacement.
+func (m *RefreshTokenManager) issue(ctx context.Context, rt, replaced *RefreshToken, now time.Time) (*TokenPair, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, expSecs, err := m.provider.IssueTokenWithClaims(ctx, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  rt.Sub,
+			ID:       jti,
+			Audience: rt.Audience,
+		},
+		Tid:    rt.Tid,
+		Roles:  rt.Roles,
+		Scopes: rt.Scopes,
+		Act:    rt.Act,
+		Extra:  rt.Extra,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rt.ID, err = randomString(16); err != nil {
+		return nil, err
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.Hash = hashSecret(secret)
+	rt.IssuedAt = now
+	rt.ExpiresAt = now.Add(m.ttl)
+	if rt.ExpiresAt.After(rt.FamilyExpiresAt) {
+		rt.ExpiresAt = rt.FamilyExpiresAt
+	}
+
+	if replaced != nil {
+		ok, err := m.store.MarkUsed(ctx, replaced.ID, rt.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// A concurrent request consumed the token first.
+		if !ok {
+			return nil, m.reused(ctx, replaced)
+		}
+	}
+
+	if err := m.store.Create(ctx, rt); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        expSecs,
+		RefreshToken:     rt.ID + "." + secret,
+		RefreshExpiresIn: int(rt.ExpiresAt.Sub(now).Seconds()),
+	}, nil
+}
+
+// lookup finds the stored state of refreshToken and checks its secret.
+func (m *RefreshTokenManager) lookup(ctx context.Context, refreshToken string) (*RefreshToken, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, kiterrors.ErrUnrecognizableToken.WithDetails("malformed refresh token")
+	}
+
+	rt, err := m.store.Get(ctx, id)
+	if err != nil {
+		if kiterrors.ErrRepoEntityNotFound.Equal(err) {
+			return nil, kiterrors.ErrBearerTokenInvalid.WithDetails("unknown refresh token")
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(rt.Hash), []byte(hashSecret(secret))) != 1 {
+		return nil, kiterrors.ErrBearerTokenInvalid.WithDetails("unknown refresh token")
+	}
+
+	return rt, nil
+}
+
+// hashSecret returns the SHA-256 digest of a random secret, such as a refresh
+// or session token, under which it is stored. Random secrets need no slow
+// hash.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes encoded in URL safe base64.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", kiterrors.WithStack(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// memoryRefreshTokenStore is an in-memory RefreshTokenStore, suitable for
+// tests and single instance services.
+type memoryRefreshTokenStore struct {
+	mu       sync.Mutex
+	tokens   map[string]RefreshToken
+	families map[string][]string
+	sweeps   sweep.Schedule
+}
+
+// NewMemoryRefreshTokenStore creates an in-memory RefreshTokenStore. Families
+// are removed once their max lifetime is passed.
+func NewMemoryRefreshTokenStore() RefreshTokenStore {
+	return &memoryRefreshTokenStore{
+		tokens:   map[string]RefreshToken{},
+		families: map[string][]string{},
+	}
+}
+
+func (s *memoryRefreshTokenStore) Create(_ context.Context, t *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[t.ID]; ok {
+		return kiterrors.WithStack(kiterrors.ErrRepoDuplicateKey)
+	}
+
+	if now := time.Now(); s.sweeps.Due(now) {
+		s.sweep(now)
+	}
+
+	// A rotation racing with a family revocation must not resurrect the
+	// family.
+	for _, id := range s.families[t.FamilyID] {
+		if s.tokens[id].Revoked {
+			t.Revoked = true
+		}
+	}
+
+	s.tokens[t.ID] = *t
+	s.families[t.FamilyID] = append(s.families[t.FamilyID], t.ID)
+
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) Get(_ context.Context, id string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return nil, kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	// The family can no longer be refreshed, forget it.
+	if !time.Now().Before(t.FamilyExpiresAt) {
+		s.deleteFamily(t.FamilyID)
+		return nil, kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	return &t, nil
+}
+
+func (s *memoryRefreshTokenStore) MarkUsed(_ context.Context, id, replacedByID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return false, kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	if t.Used {
+		return false, nil
+	}
+
+	t.Used = true
+	t.ReplacedByID = replacedByID
+	s.tokens[id] = t
+
+	return true, nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range s.families[familyID] {
+		t := s.tokens[id]
+		t.Revoked = true
+		s.tokens[id] = t
+	}
+
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) deleteFamily(familyID string) {
+	for _, id := range s.families[familyID] {
+		delete(s.tokens, id)
+	}
+	delete(s.families, familyID)
+}
+
+func (s *memoryRefreshTokenStore) sweep(now time.Time) {
+	for familyID, ids := range s.families {
+		if len(ids) > 0 && !now.Before(s.tokens[ids[0]].FamilyExpiresAt) {
+			s.deleteFamily(familyID)
+		}
+	}
+}
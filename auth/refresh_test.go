@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRefreshTokenManagerKeepsClaimsOnRefresh(t *testing.T) {
+	j := newTestJWT(t, KeyRingConfig{Active: "k1", Keys: []KeyConfig{newECKeyConfig(t, "k1")}})
+	m := NewRefreshTokenManager(j, NewMemoryRefreshTokenStore())
+
+	ctx := context.Background()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "alice", Audience: jwt.ClaimStrings{"api"}},
+		Tid:              "acme",
+		Roles:            []string{"user"},
+		Scopes:           SpaceDelimited{"read"},
+		Act:              &Actor{Sub: "support", Tid: "acme"},
+		Extra:            map[string]interface{}{"plan": "pro"},
+	}
+
+	pair, err := m.IssueTokenPair(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if pair, err = m.Refresh(ctx, pair.RefreshToken); err != nil {
+			t.Fatalf("refresh %d: %v", i, err)
+		}
+	}
+
+	c, err := j.ParseTokenWithClaims(ctx, pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Subject != "alice" || c.Tid != "acme" {
+		t.Errorf("subject = %q, tenant = %q", c.Subject, c.Tid)
+	}
+	if !reflect.DeepEqual(c.Audience, claims.Audience) {
+		t.Errorf("audience = %v, want %v", c.Audience, claims.Audience)
+	}
+	if !reflect.DeepEqual(c.Act, claims.Act) {
+		t.Errorf("act = %+v, want %+v", c.Act, claims.Act)
+	}
+	if !reflect.DeepEqual(c.Extra, claims.Extra) {
+		t.Errorf("extra = %v, want %v", c.Extra, claims.Extra)
+	}
+	if !reflect.DeepEqual(c.Roles, claims.Roles) || !reflect.DeepEqual(c.Scopes, claims.Scopes) {
+		t.Errorf("roles = %v, scopes = %v", c.Roles, c.Scopes)
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultSubjectRevocationTTL = 30 * 24 * time.Hour
+
+// RevocationStore keeps revoked tokens, by token id, and revoked subjects,
+// for which every token issued before a given time is revoked.
+type RevocationStore interface {
+	// RevokeToken revokes the token with jti until it expires.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+
+	// RevokeSubject revokes every token of sub issued before issuedBefore,
+	// which logs the subject out everywhere. Issue times are compared at the
+	// precision of the "iat" claim, the second, so that tokens issued
+	// within the second of issuedBefore are revoked as well: a new login in
+	// that second must be retried.
+	RevokeSubject(ctx context.Context, sub string, issuedBefore time.Time) error
+
+	// IsRevoked reports whether the token is revoked. A zero issuedAt, when
+	// the issue time of the token is unknown, is treated as revoked whenever
+	// the subject has been revoked.
+	IsRevoked(ctx context.Context, jti, sub string, issuedAt time.Time) (bool, error)
+}
+
+// memoryRevocationStore is an in-memory RevocationStore whose entries expire
+// once the tokens they revoke can no longer be valid.
+type memoryRevocationStore struct {
+	mu         sync.Mutex
+	subjectTTL time.Duration
+	tokens     map[string]time.Time
+	subjects   map[string]subjectRevocation
+}
+
+type subjectRevocation struct {
+	issuedBefore time.Time
+	expiresAt    time.Time
+}
+
+// NewMemoryRevocationStore creates an in-memory RevocationStore. Subject
+// revocations are kept for subjectTTL, which must be at least the longest
+// lifetime of an access token; zero defaults to 30 days.
+func NewMemoryRevocationStore(subjectTTL time.Duration) RevocationStore {
+	if subjectTTL <= 0 {
+		subjectTTL = defaultSubjectRevocationTTL
+	}
+
+	return &memoryRevocationStore{
+		subjectTTL: subjectTTL,
+		tokens:     map[string]time.Time{},
+		subjects:   map[string]subjectRevocation{},
+	}
+}
+
+func (s *memoryRevocationStore) RevokeToken(_ context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+	s.tokens[jti] = expiresAt
+
+	return nil
+}
+
+func (s *memoryRevocationStore) RevokeSubject(_ context.Context, sub string, issuedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	// Tokens carry their issue time in seconds, so the whole second of the
+	// revocation is revoked, IsRevoked comparing issue times to it.
+	issuedBefore = issuedBefore.Truncate(time.Second)
+
+	if r, ok := s.subjects[sub]; ok && r.issuedBefore.After(issuedBefore) {
+		issuedBefore = r.issuedBefore
+	}
+	s.subjects[sub] = subjectRevocation{issuedBefore: issuedBefore, expiresAt: now.Add(s.subjectTTL)}
+
+	return nil
+}
+
+func (s *memoryRevocationStore) IsRevoked(_ context.Context, jti, sub string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if jti != "" {
+		if exp, ok := s.tokens[jti]; ok && now.Before(exp) {
+			return true, nil
+		}
+	}
+
+	if r, ok := s.subjects[sub]; ok && now.Before(r.expiresAt) {
+		if issuedAt.IsZero() || !issuedAt.After(r.issuedBefore) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// sweep removes expired entries.
+func (s *memoryRevocationStore) sweep(now time.Time) {
+	for jti, exp := range s.tokens {
+		if !now.Before(exp) {
+			delete(s.tokens, jti)
+		}
+	}
+
+	for sub, r := range s.subjects {
+		if !now.Before(r.expiresAt) {
+			delete(s.subjects, sub)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	"github.com/quocdaitrn/golang-kit/internal/sweep"
+)
+
+const (
+	defaultSessionIdleTimeout     = 30 * time.Minute
+	defaultSessionAbsoluteTimeout = 12 * time.Hour
+
+	// sessionTouchInterval limits how often the last activity of a session
+	// is written to the store.
+	sessionTouchInterval = time.Minute
+)
+
+// Session is the stored state of a server-side session. The session token
+// held by the client is never stored, ID is its hash and can be shown to the
+// user to list and revoke sessions.
+type Session struct {
+	ID string `json:"id"`
+
+	Sub    string   `json:"sub"`
+	Tid    string   `json:"tid,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Attributes holds application data, such as the user agent and the
+	// address of the client.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// SessionStore persists sessions.
+type SessionStore interface {
+	// Create stores a new session.
+	Create(ctx context.Context, s *Session) error
+
+	// Get returns the session with id, or ErrRepoEntityNotFound.
+	Get(ctx context.Context, id string) (*Session, error)
+
+	// Touch updates the last activity of the session.
+	Touch(ctx context.Context, id string, lastSeenAt time.Time) error
+
+	// Delete deletes the session, if it exists.
+	Delete(ctx context.Context, id string) error
+
+	// ListBySubject returns the sessions of sub.
+	ListBySubject(ctx context.Context, sub string) ([]*Session, error)
+
+	// DeleteBySubject deletes the sessions of sub.
+	DeleteBySubject(ctx context.Context, sub string) error
+}
+
+// SessionOption configures a SessionManager.
+type SessionOption func(*SessionManager)
+
+// WithSessionIdleTimeout sets how long a session lasts without activity, 30
+// minutes by default.
+func WithSessionIdleTimeout(d time.Duration) SessionOption {
+	return func(m *SessionManager) {
+		m.idleTimeout = d
+	}
+}
+
+// WithSessionAbsoluteTimeout sets how long a session lasts regardless of
+// activity, 12 hours by default.
+func WithSessionAbsoluteTimeout(d time.Duration) SessionOption {
+	return func(m *SessionManager) {
+		m.absoluteTimeout = d
+	}
+}
+
+// SessionManager manages server-side sessions. Session tokens are carried by
+// the transport, such as http.SessionCookie, through the kitcontext.SessionRef
+// of the context.
+type SessionManager struct {
+	store           SessionStore
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+}
+
+// NewSessionManager creates a SessionManager storing sessions in s.
+func NewSessionManager(s SessionStore, opts ...SessionOption) *SessionManager {
+	m := &SessionManager{
+		store:           s,
+		idleTimeout:     defaultSessionIdleTimeout,
+		absoluteTimeout: defaultSessionAbsoluteTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Create starts a session for claims and returns its token. The token is also
+// set to the session of the context, if any, replacing the current session
+// which should have been destroyed.
+func (m *SessionManager) Create(ctx context.Context, claims *Claims, attrs map[string]string) (string, *Session, error) {
+	now := time.Now()
+
+	s := &Session{
+		Sub:        claims.Subject,
+		Tid:        claims.Tid,
+		Roles:      claims.Roles,
+		Scopes:     claims.Scopes,
+		Attributes: attrs,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(m.absoluteTimeout),
+	}
+
+	token, err := m.create(ctx, s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, s, nil
+}
+
+// Get returns the active session of token. Unknown sessions fail with
+// ErrUnauthorized and expired ones with ErrTokenExpired.
+func (m *SessionManager) Get(ctx context.Context, token string) (*Session, error) {
+	id := hashSecret(token)
+
+	s, err := m.store.Get(ctx, id)
+	if kiterrors.ErrRepoEntityNotFound.Equal(err) {
+		return nil, kiterrors.ErrUnauthorized.WithDetails("unknown session")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(s.ExpiresAt) || !now.Before(s.LastSeenAt.Add(m.idleTimeout)) {
+		_ = m.store.Delete(ctx, id)
+		return nil, kiterrors.ErrTokenExpired.WithDetails("session expired")
+	}
+
+	if now.Sub(s.LastSeenAt) >= sessionTouchInterval {
+		if err := m.store.Touch(ctx, id, now); err != nil {
+			return nil, err
+		}
+		s.LastSeenAt = now
+	}
+
+	return s, nil
+}
+
+// Regenerate replaces the session of the context with a new one, keeping its
+// attributes and absolute expiry, to prevent session fixation. It must be
+// called whenever the privileges of the user change, with the new claims, or
+// nil to keep them.
+func (m *SessionManager) Regenerate(ctx context.Context, claims *Claims) (*Session, error) {
+	ref := kitcontext.SessionRefFromContext(ctx)
+	if ref == nil || ref.Token() == "" {
+		return nil, kiterrors.ErrUnauthorized.WithDetails("missing session")
+	}
+
+	old, err := m.Get(ctx, ref.Token())
+	if err != nil {
+		return nil, err
+	}
+
+	s := *old
+	s.LastSeenAt = time.Now()
+	if claims != nil {
+		s.Sub, s.Tid, s.Roles, s.Scopes = claims.Subject, claims.Tid, claims.Roles, claims.Scopes
+	}
+
+	if _, err := m.create(ctx, &s); err != nil {
+		return nil, err
+	}
+
+	if err := m.store.Delete(ctx, old.ID); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Destroy ends the session of the context.
+func (m *SessionManager) Destroy(ctx context.Context) error {
+	ref := kitcontext.SessionRefFromContext(ctx)
+	if ref == nil || ref.Token() == "" {
+		return nil
+	}
+
+	if err := m.store.Delete(ctx, hashSecret(ref.Token())); err != nil {
+		return err
+	}
+
+	ref.SetToken("")
+
+	return nil
+}
+
+// List returns the active sessions of sub, the most recently used first.
+func (m *SessionManager) List(ctx context.Context, sub string) ([]*Session, error) {
+	sessions, err := m.store.ListBySubject(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		if now.Before(s.ExpiresAt) && now.Before(s.LastSeenAt.Add(m.idleTimeout)) {
+			active = append(active, s)
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastSeenAt.After(active[j].LastSeenAt)
+	})
+
+	return active, nil
+}
+
+// Revoke ends the session id of sub. Sessions of other subjects are reported
+// as not found.
+func (m *SessionManager) Revoke(ctx context.Context, sub, id string) error {
+	s, err := m.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if s.Sub != sub {
+		return kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	return m.store.Delete(ctx, id)
+}
+
+// RevokeAll ends every session of sub, such as after a password change.
+func (m *SessionManager) RevokeAll(ctx context.Context, sub string) error {
+	return m.store.DeleteBySubject(ctx, sub)
+}
+
+// create stores s under a new token and sets it to the session of the
+// context.
+func (m *SessionManager) create(ctx context.Context, s *Session) (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.ID = hashSecret(token)
+	if err := m.store.Create(ctx, s); err != nil {
+		return "", err
+	}
+
+	if ref := kitcontext.SessionRefFromContext(ctx); ref != nil {
+		ref.SetToken(token)
+	}
+
+	return token, nil
+}
+
+type sessionKeyType struct{}
+
+var sessionKey = sessionKeyType{}
+
+// SessionFromContext returns the session authenticated by
+// AuthenticateSession.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey).(*Session)
+	return s
+}
+
+// AuthenticateSession returns a middleware authenticating the session placed
+// in the context by the transport, such as http.SessionCookie. The identity
+// is added to the context like Authenticate does.
+func AuthenticateSession(m *SessionManager) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ref := kitcontext.SessionRefFromContext(ctx)
+			if ref == nil || ref.Token() == "" {
+				return nil, kiterrors.ErrUnauthorized.WithDetails("missing session")
+			}
+
+			s, err := m.Get(ctx, ref.Token())
+			if err != nil {
+				return nil, err
+			}
+
+			var attrs map[string]interface{}
+			if len(s.Attributes) > 0 {
+				attrs = make(map[string]interface{}, len(s.Attributes))
+				for k, v := range s.Attributes {
+					attrs[k] = v
+				}
+			}
+
+			ctx = context.WithValue(ctx, sessionKey, s)
+			ctx = kitcontext.WithPrincipal(ctx, kitcontext.Principal{
+				Sub:        s.Sub,
+				Tid:        s.Tid,
+				Roles:      s.Roles,
+				Scopes:     s.Scopes,
+				AuthMethod: kitcontext.AuthMethodSession,
+				TokenID:    s.ID,
+				AuthTime:   s.CreatedAt,
+				Attributes: attrs,
+			})
+			return next(ctx, request)
+		}
+	}
+}
+
+// memorySessionStore is an in-memory SessionStore, suitable for tests and
+// single instance services.
+type memorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]Session
+	bySubject map[string]map[string]bool
+	sweeps    sweep.Schedule
+}
+
+// NewMemorySessionStore creates an in-memory SessionStore. Sessions are
+// removed when they are revoked, found expired, or their absolute timeout is
+// passed, which is checked periodically on every operation.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions:  map[string]Session{},
+		bySubject: map[string]map[string]bool{},
+	}
+}
+
+func (s *memorySessionStore) Create(_ context.Context, sess *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+
+	if _, ok := s.sessions[sess.ID]; ok {
+		return kiterrors.WithStack(kiterrors.ErrRepoDuplicateKey)
+	}
+
+	s.sessions[sess.ID] = *sess
+	if s.bySubject[sess.Sub] == nil {
+		s.bySubject[sess.Sub] = map[string]bool{}
+	}
+	s.bySubject[sess.Sub][sess.ID] = true
+
+	return nil
+}
+
+func (s *memorySessionStore) Get(_ context.Context, id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	return &sess, nil
+}
+
+func (s *memorySessionStore) Touch(_ context.Context, id string, lastSeenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return kiterrors.WithStack(kiterrors.ErrRepoEntityNotFound)
+	}
+
+	sess.LastSeenAt = lastSeenAt
+	s.sessions[id] = sess
+
+	return nil
+}
+
+func (s *memorySessionStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+
+	s.delete(id)
+
+	return nil
+}
+
+func (s *memorySessionStore) ListBySubject(_ context.Context, sub string) ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+
+	sessions := make([]*Session, 0, len(s.bySubject[sub]))
+	for id := range s.bySubject[sub] {
+		sess := s.sessions[id]
+		sessions = append(sessions, &sess)
+	}
+
+	return sessions, nil
+}
+
+func (s *memorySessionStore) DeleteBySubject(_ context.Context, sub string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+
+	for id := range s.bySubject[sub] {
+		delete(s.sessions, id)
+	}
+	delete(s.bySubject, sub)
+
+	return nil
+}
+
+func (s *memorySessionStore) delete(id string) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+
+	delete(s.sessions, id)
+	delete(s.bySubject[sess.Sub], id)
+	if len(s.bySubject[sess.Sub]) == 0 {
+		delete(s.bySubject, sess.Sub)
+	}
+}
+
+// sweep removes the sessions past their absolute timeout, at most once per
+// sweep.Interval.
+func (s *memorySessionStore) sweep(now time.Time) {
+	if !s.sweeps.Due(now) {
+		return
+	}
+
+	for id, sess := range s.sessions {
+		if !now.Before(sess.ExpiresAt) {
+			s.delete(id)
+		}
+	}
+}
@@ -27,6 +27,9 @@ func (k contextBool) Get(ctx context.Context) bool {
 const (
 	// ContextAuthorization is used for storing authorization token of user.
 	ContextAuthorization contextString = iota
+
+	// ContextAPIKey is used for storing API key of client.
+	ContextAPIKey
 )
 
 type contextString int
@@ -1,17 +1,41 @@
 package context
 
-import "context"
+import (
+	"context"
+	"crypto/x509"
+	"time"
+)
 
 // UID stores current user's identity.
 type UID struct {
 	Sub string `json:"user_id"`
 	Tid string `json:"tid"`
+
+	// Act is the identity acting on behalf of the user when it is
+	// impersonated, as the "act" claim of RFC 8693. It may itself carry the
+	// previous actors of a delegation chain.
+	Act *UID `json:"act,omitempty"`
 }
 
 func (u UID) IsZero() bool {
 	return u.Sub == "" && u.Tid == ""
 }
 
+// IsImpersonated reports whether another identity acts on behalf of the user.
+func (u UID) IsImpersonated() bool {
+	return u.Act != nil
+}
+
+// Actor returns the identity really performing the request, the current
+// actor when the user is impersonated and the user otherwise.
+func (u UID) Actor() UID {
+	if u.Act != nil {
+		return *u.Act
+	}
+
+	return u
+}
+
 type uidKeyType struct{}
 
 var uidKey = uidKeyType{}
@@ -28,3 +52,236 @@ func UIDFromContext(ctx context.Context) UID {
 
 	return UID{}
 }
+
+// ActorFromContext returns the identity really performing the current request,
+// which audit logs must record along with UIDFromContext.
+func ActorFromContext(ctx context.Context) UID {
+	return UIDFromContext(ctx).Actor()
+}
+
+// Authentication methods of principals.
+const (
+	AuthMethodBearer     = "bearer"
+	AuthMethodAPIKey     = "api_key"
+	AuthMethodClientCert = "client_cert"
+	AuthMethodSession    = "session"
+	AuthMethodBasic      = "basic"
+	AuthMethodDigest     = "digest"
+)
+
+// Principal is the authenticated identity of the current request with the
+// details of its authentication.
+type Principal struct {
+	Sub    string   `json:"sub"`
+	Tid    string   `json:"tid,omitempty"`
+	Act    *UID     `json:"act,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+
+	// AuthMethod is how the principal authenticated, one of the
+	// AuthMethod* values.
+	AuthMethod string `json:"auth_method,omitempty"`
+
+	// TokenID identifies the credential used, such as the token id, the
+	// API key id or the session id. AuthTime is when the principal
+	// authenticated: the issue time of bearer tokens and sessions, the time
+	// of the request for the other methods.
+	TokenID  string    `json:"token_id,omitempty"`
+	AuthTime time.Time `json:"auth_time"`
+
+	// Attributes holds application specific values, such as the extra
+	// claims of the token.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// UID returns the identity of the principal.
+func (p Principal) UID() UID {
+	return UID{Sub: p.Sub, Tid: p.Tid, Act: p.Act}
+}
+
+// IsZero reports whether no principal is authenticated.
+func (p Principal) IsZero() bool {
+	return p.UID().IsZero()
+}
+
+type principalKeyType struct{}
+
+var principalKey = principalKeyType{}
+
+// WithPrincipal returns a copy of ctx carrying p. The UID, roles and scopes
+// of p are also set, so that UIDFromContext, RolesFromContext and
+// ScopesFromContext keep working.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey, p)
+	ctx = WithUID(ctx, p.UID())
+	ctx = WithRoles(ctx, p.Roles)
+	ctx = WithScopes(ctx, p.Scopes)
+	return ctx
+}
+
+// PrincipalFromContext returns the principal of the current request. When
+// only the UID, roles and scopes were set, the principal is built from them.
+func PrincipalFromContext(ctx context.Context) Principal {
+	uid := UIDFromContext(ctx)
+
+	p, ok := ctx.Value(principalKey).(Principal)
+	if !ok || p.Sub != uid.Sub || p.Tid != uid.Tid {
+		p = Principal{Sub: uid.Sub, Tid: uid.Tid, Act: uid.Act}
+	}
+
+	p.Roles = RolesFromContext(ctx)
+	p.Scopes = ScopesFromContext(ctx)
+
+	return p
+}
+
+type scopesKeyType struct{}
+
+var scopesKey = scopesKeyType{}
+
+// WithScopes returns a copy of ctx carrying the scopes granted to the current
+// request.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// ScopesFromContext returns the scopes granted to the current request.
+func ScopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesKey).([]string)
+	return scopes
+}
+
+type rolesKeyType struct{}
+
+var rolesKey = rolesKeyType{}
+
+// WithRoles returns a copy of ctx carrying the roles of the current user.
+func WithRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, rolesKey, roles)
+}
+
+// RolesFromContext returns the roles of the current user.
+func RolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey).([]string)
+	return roles
+}
+
+// Sources of credentials.
+const (
+	CredentialSourceHeader = "header"
+	CredentialSourceCookie = "cookie"
+	CredentialSourceQuery  = "query"
+)
+
+// Credential is a credential found in a request, such as an access token.
+type Credential struct {
+	// Source is where the credential was found, one of the
+	// CredentialSource* values, and Name the header, cookie or query
+	// parameter name.
+	Source string
+	Name   string
+	Value  string
+}
+
+type credentialsKeyType struct{}
+
+var credentialsKey = credentialsKeyType{}
+
+// WithCredentials returns a copy of ctx carrying the credentials found in the
+// current request.
+func WithCredentials(ctx context.Context, creds []Credential) context.Context {
+	return context.WithValue(ctx, credentialsKey, creds)
+}
+
+// CredentialsFromContext returns the credentials found in the current request.
+func CredentialsFromContext(ctx context.Context) []Credential {
+	creds, _ := ctx.Value(credentialsKey).([]Credential)
+	return creds
+}
+
+type clientCertificateKeyType struct{}
+
+var clientCertificateKey = clientCertificateKeyType{}
+
+// WithClientCertificate returns a copy of ctx carrying the verified client
+// certificate of the current request.
+func WithClientCertificate(ctx context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, clientCertificateKey, cert)
+}
+
+// ClientCertificateFromContext returns the verified client certificate of the
+// current request, nil if none.
+func ClientCertificateFromContext(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(clientCertificateKey).(*x509.Certificate)
+	return cert
+}
+
+// SessionRef refers to the session of the current request. It is shared by
+// the transport, which reads and writes the session cookie, and the endpoints,
+// which change the session when it is created, regenerated or destroyed.
+type SessionRef struct {
+	token   string
+	changed bool
+}
+
+// NewSessionRef creates a SessionRef to the session of token, empty if the
+// request has none.
+func NewSessionRef(token string) *SessionRef {
+	return &SessionRef{token: token}
+}
+
+// Token returns the session token.
+func (r *SessionRef) Token() string {
+	return r.token
+}
+
+// SetToken changes the session token, an empty token ending the session.
+func (r *SessionRef) SetToken(token string) {
+	r.token = token
+	r.changed = true
+}
+
+// Changed reports whether the session token changed during the request.
+func (r *SessionRef) Changed() bool {
+	return r.changed
+}
+
+type sessionRefKeyType struct{}
+
+var sessionRefKey = sessionRefKeyType{}
+
+// WithSessionRef returns a copy of ctx carrying the session of the current
+// request.
+func WithSessionRef(ctx context.Context, ref *SessionRef) context.Context {
+	return context.WithValue(ctx, sessionRefKey, ref)
+}
+
+// SessionRefFromContext returns the session of the current request, nil if
+// the transport doesn't support sessions.
+func SessionRefFromContext(ctx context.Context) *SessionRef {
+	ref, _ := ctx.Value(sessionRefKey).(*SessionRef)
+	return ref
+}
+
+// RequestTarget is the method and the URI of the current request, as sent by
+// the client.
+type RequestTarget struct {
+	Method string
+	URI    string
+}
+
+type requestTargetKeyType struct{}
+
+var requestTargetKey = requestTargetKeyType{}
+
+// WithRequestTarget returns a copy of ctx carrying the target of the current
+// request.
+func WithRequestTarget(ctx context.Context, t RequestTarget) context.Context {
+	return context.WithValue(ctx, requestTargetKey, t)
+}
+
+// RequestTargetFromContext returns the target of the current request.
+func RequestTargetFromContext(ctx context.Context) RequestTarget {
+	t, _ := ctx.Value(requestTargetKey).(RequestTarget)
+	return t
+}
@@ -59,6 +59,17 @@ func Errorf(format string, args ...interface{}) error {
 	return errors.Errorf(format, args...)
 }
 
+// Is reports whether any error in err's chain matches target.
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, and if so,
+// sets target to that error value and returns true.
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
 // Error represents an error in kit.
 type Error struct {
 	// The internal code of this error.
@@ -192,6 +203,7 @@ const (
 	ErrCodeServiceUnavailable
 	ErrCodeRequestTimeout
 	ErrCodeValidatorJSONSchemaNotFound
+	ErrCodeTokenExpired
 )
 
 var businessErrors = map[int]bool{
@@ -222,6 +234,7 @@ var businessErrors = map[int]bool{
 	ErrCodeBadGateway:                 true,
 	ErrCodeServiceUnavailable:         true,
 	ErrCodeRequestTimeout:             true,
+	ErrCodeTokenExpired:               true,
 }
 
 // IsBusinessError reports if input error is a business error.
@@ -374,6 +387,17 @@ var (
 	// blacklisted.
 	ErrTokenBlacklisted = NewError(ErrCodeTokenBlacklisted, "the token has been blacklisted")
 
+	// ErrNoCredentialsMatch is an error which occurres when the credentials
+	// of the request match no known credentials.
+	ErrNoCredentialsMatch = NewError(ErrCodeNoCredentialsMatch, "no credentials match")
+
+	// ErrConsumerNotFound is an error which occurres when no consumer owns
+	// the credentials of the request.
+	ErrConsumerNotFound = NewError(ErrCodeConsumerNotFound, "consumer not found")
+
+	// ErrTokenExpired is an error which occurres when the token is expired.
+	ErrTokenExpired = NewError(ErrCodeTokenExpired, "the token has expired")
+
 	// ErrForbidden is a common forbidden error.
 	ErrForbidden = NewError(ErrCodeForbidden, "forbidden")
 
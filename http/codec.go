@@ -25,6 +25,13 @@ func DefaultErrorEncoder(ctx context.Context, err error, w http.ResponseWriter)
 	contentType := "application/json; charset=utf-8"
 	w.Header().Set("Content-Type", contentType)
 
+	var c challenger
+	if kiterrors.As(err, &c) {
+		for _, challenge := range c.Challenges() {
+			w.Header().Add(HeaderWWWAuthenticate, challenge)
+		}
+	}
+
 	w.WriteHeader(he.HTTPStatus)
 
 	if err := json.NewEncoder(w).Encode(he); err != nil {
@@ -32,6 +39,13 @@ func DefaultErrorEncoder(ctx context.Context, err error, w http.ResponseWriter)
 	}
 }
 
+// challenger is implemented by authentication errors carrying the
+// "WWW-Authenticate" challenges the client must answer, such as the ones of
+// auth.AuthenticateBasic and auth.AuthenticateDigest.
+type challenger interface {
+	Challenges() []string
+}
+
 // errorer is implemented by all concrete response types that may contain
 // errors. It allows us to change the HTTP response code without needing to
 // trigger an endpoint (transport-level) error. For more information, read the
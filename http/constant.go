@@ -11,7 +11,9 @@ const (
 
 // Headers
 const (
-	HeaderContentType   = "Content-Type"
-	HeaderAccept        = "Accept"
-	HeaderAuthorization = "Authorization"
+	HeaderContentType     = "Content-Type"
+	HeaderAccept          = "Accept"
+	HeaderAuthorization   = "Authorization"
+	HeaderAPIKey          = "X-API-Key"
+	HeaderWWWAuthenticate = "WWW-Authenticate"
 )
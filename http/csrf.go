@@ -0,0 +1,357 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// HeaderCSRFToken is the header carrying the CSRF token of requests sent by
+// scripts.
+const HeaderCSRFToken = "X-CSRF-Token"
+
+const (
+	defaultCSRFCookieName  = "__Host-csrf_token"
+	insecureCSRFCookieName = "csrf_token"
+	defaultCSRFFormField   = "csrf_token"
+	defaultCSRFMaxBodySize = 10 << 20
+)
+
+// CSRFProtector protects cookie authenticated endpoints from cross-site
+// request forgery. Requests with unsafe methods must come from a trusted
+// origin, according to their Origin or Referer header, and carry a CSRF token
+// in the "X-CSRF-Token" header or in the form field.
+//
+// The token is derived from the session token of the context, set by
+// SessionCookie.PopulateRequest which must run first. Requests without
+// session, such as a login form, use a double-submit cookie instead, readable
+// by scripts. Its "__Host-" name prefix makes browsers reject it unless it was
+// set by this host over HTTPS, so that a sibling domain can't plant a token
+// it knows.
+type CSRFProtector struct {
+	secret         []byte
+	trustedOrigins []string
+	cookieName     string
+	formField      string
+	insecure       bool
+	maxBodySize    int64
+}
+
+// CSRFOption configures a CSRFProtector.
+type CSRFOption func(*CSRFProtector)
+
+// WithTrustedOrigins allows requests from origins other than the one of the
+// request, as in "https://app.example.org".
+func WithTrustedOrigins(origins ...string) CSRFOption {
+	return func(p *CSRFProtector) {
+		p.trustedOrigins = origins
+	}
+}
+
+// WithCSRFCookieName sets the name of the double-submit cookie,
+// "__Host-csrf_token" by default, or "csrf_token" with
+// WithInsecureCSRFCookie. Names without the "__Host-" prefix let sibling
+// domains set the cookie.
+func WithCSRFCookieName(name string) CSRFOption {
+	return func(p *CSRFProtector) {
+		p.cookieName = name
+	}
+}
+
+// WithCSRFFormField sets the form field carrying the token, "csrf_token" by
+// default.
+func WithCSRFFormField(name string) CSRFOption {
+	return func(p *CSRFProtector) {
+		p.formField = name
+	}
+}
+
+// WithInsecureCSRFCookie drops the Secure attribute and the "__Host-" prefix
+// of the double-submit cookie, for local development over plain HTTP.
+func WithInsecureCSRFCookie() CSRFOption {
+	return func(p *CSRFProtector) {
+		p.insecure = true
+		if p.cookieName == defaultCSRFCookieName {
+			p.cookieName = insecureCSRFCookieName
+		}
+	}
+}
+
+// NewCSRFProtector creates a CSRFProtector deriving and signing tokens with
+// secret.
+func NewCSRFProtector(secret []byte, opts ...CSRFOption) *CSRFProtector {
+	p := &CSRFProtector{
+		secret:      secret,
+		cookieName:  defaultCSRFCookieName,
+		formField:   defaultCSRFFormField,
+		maxBodySize: defaultCSRFMaxBodySize,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+type csrfResultKeyType struct{}
+
+var csrfResultKey = csrfResultKeyType{}
+
+type csrfResult struct {
+	protector *CSRFProtector
+	ref       *kitcontext.SessionRef
+
+	// cookieToken is the double-submit token, and newCookie reports whether
+	// it must be set to the response.
+	cookieToken string
+	newCookie   bool
+
+	err error
+}
+
+// PopulateRequest is a RequestFunc verifying the CSRF token of requests with
+// unsafe methods and populating the result to the context. RequireCSRFToken
+// rejects the requests which failed.
+func (p *CSRFProtector) PopulateRequest(ctx context.Context, r *http.Request) context.Context {
+	res := &csrfResult{protector: p, ref: kitcontext.SessionRefFromContext(ctx)}
+
+	if c, err := r.Cookie(p.cookieName); err == nil && p.validCookieToken(c.Value) {
+		res.cookieToken = c.Value
+	}
+
+	if !isSafeMethod(r.Method) {
+		res.err = p.verify(r, res)
+	}
+
+	// Issue a double-submit token for the forms rendered without session.
+	if res.cookieToken == "" && (res.ref == nil || res.ref.Token() == "") {
+		if token, err := p.newCookieToken(); err == nil {
+			res.cookieToken, res.newCookie = token, true
+		}
+	}
+
+	return context.WithValue(ctx, csrfResultKey, res)
+}
+
+// SetResponse is a ServerResponseFunc setting the double-submit cookie issued
+// during the request.
+func (p *CSRFProtector) SetResponse(ctx context.Context, w http.ResponseWriter) context.Context {
+	res, ok := ctx.Value(csrfResultKey).(*csrfResult)
+	if !ok || !res.newCookie {
+		return ctx
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.cookieName,
+		Value:    res.cookieToken,
+		Path:     "/",
+		Secure:   !p.insecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return ctx
+}
+
+// RequireCSRFToken returns a middleware rejecting with ErrForbidden the
+// requests whose CSRF token was not verified by CSRFProtector.PopulateRequest.
+func RequireCSRFToken() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			res, ok := ctx.Value(csrfResultKey).(*csrfResult)
+			if !ok {
+				return nil, kiterrors.ErrForbidden.WithDetails("CSRF token not verified")
+			}
+
+			if res.err != nil {
+				return nil, res.err
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// CSRFTokenFromContext returns the CSRF token to embed in the forms and
+// pages of the current request. It follows the session of the context, so
+// that it must be fetched again after the session is created or
+// regenerated.
+func CSRFTokenFromContext(ctx context.Context) string {
+	res, ok := ctx.Value(csrfResultKey).(*csrfResult)
+	if !ok {
+		return ""
+	}
+
+	if res.ref != nil && res.ref.Token() != "" {
+		return res.protector.sessionToken(res.ref.Token())
+	}
+
+	return res.cookieToken
+}
+
+// verify verifies the origin and the token of r.
+func (p *CSRFProtector) verify(r *http.Request, res *csrfResult) error {
+	if err := p.verifyOrigin(r); err != nil {
+		return err
+	}
+
+	token := r.Header.Get(HeaderCSRFToken)
+	if token == "" {
+		var err error
+		if token, err = p.formToken(r); err != nil {
+			return err
+		}
+	}
+	if token == "" {
+		return kiterrors.ErrForbidden.WithDetails("missing CSRF token")
+	}
+
+	var expected string
+	switch {
+	case res.ref != nil && res.ref.Token() != "":
+		expected = p.sessionToken(res.ref.Token())
+	case res.cookieToken != "":
+		expected = res.cookieToken
+	default:
+		return kiterrors.ErrForbidden.WithDetails("missing CSRF cookie")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return kiterrors.ErrForbidden.WithDetails("invalid CSRF token")
+	}
+
+	return nil
+}
+
+// verifyOrigin checks that r comes from its own origin or a trusted one.
+// Requests without Origin nor Referer, sent by non browser clients, only
+// depend on the token.
+func (p *CSRFProtector) verifyOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		ref, err := url.Parse(r.Referer())
+		if err != nil {
+			return kiterrors.ErrForbidden.WithDetails("invalid Referer")
+		}
+		if ref.Host == "" {
+			if origin == "null" {
+				return kiterrors.ErrForbidden.WithDetails("untrusted origin null")
+			}
+			return nil
+		}
+		origin = ref.Scheme + "://" + ref.Host
+	}
+
+	if strings.EqualFold(origin, requestOrigin(r)) {
+		return nil
+	}
+
+	for _, o := range p.trustedOrigins {
+		if strings.EqualFold(origin, o) {
+			return nil
+		}
+	}
+
+	return kiterrors.ErrForbidden.WithDetails("untrusted origin " + origin)
+}
+
+// formToken returns the token of the form field of form requests. Bodies
+// which can't be read, such as the ones exceeding the max body size, fail
+// with ErrBadRequest.
+func (p *CSRFProtector) formToken(r *http.Request) (string, error) {
+	ctyp := r.Header.Get(HeaderContentType)
+	if !strings.HasPrefix(ctyp, MIMEApplicationForm) && !strings.HasPrefix(ctyp, MIMEMultipartForm) {
+		return "", nil
+	}
+
+	// Parse a copy of the body, so that Bind can still read it.
+	if _, err := readBody(r, p.maxBodySize); err != nil {
+		return "", kiterrors.ErrBadRequest.WithDetails(err)
+	}
+
+	// Restore the body and forget the parsed form, removing the temporary
+	// files of multipart forms, so that Bind parses the request afresh.
+	defer func() {
+		if r.MultipartForm != nil {
+			_ = r.MultipartForm.RemoveAll()
+		}
+		r.Form, r.PostForm, r.MultipartForm = nil, nil, nil
+		r.Body, _ = r.GetBody()
+	}()
+
+	if strings.HasPrefix(ctyp, MIMEMultipartForm) {
+		if err := r.ParseMultipartForm(defaultMemory); err != nil {
+			return "", nil
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return "", nil
+	}
+
+	return r.PostFormValue(p.formField), nil
+}
+
+// sessionToken derives the CSRF token of a session.
+func (p *CSRFProtector) sessionToken(session string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("session:" + session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newCookieToken returns a random double-submit token signed with the
+// secret, so that only tokens issued by the server are accepted.
+func (p *CSRFProtector) newCookieToken() (string, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", kiterrors.WithStack(err)
+	}
+
+	n := base64.RawURLEncoding.EncodeToString(nonce)
+
+	return n + "." + p.cookieSignature(n), nil
+}
+
+func (p *CSRFProtector) validCookieToken(token string) bool {
+	n, sig, ok := strings.Cut(token, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(p.cookieSignature(n)))
+}
+
+func (p *CSRFProtector) cookieSignature(nonce string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("cookie:" + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// isSafeMethod reports whether method is not supposed to change state.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// requestOrigin returns the origin of r, honoring the X-Forwarded-Proto header
+// of TLS terminating proxies.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+
+	return scheme + "://" + r.Host
+}
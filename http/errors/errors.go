@@ -107,6 +107,9 @@ var kitError2HTTPErrorMapping = map[int]HTTPError{
 	kiterrors.ErrCodeMultipleTokenProvided:      *HTTPErrMultipleTokenProvied,
 	kiterrors.ErrCodeUnrecognizableToken:        *HTTPErrUnrecognizableToken,
 	kiterrors.ErrCodeTokenBlacklisted:           *HTTPErrTokenBlacklisted,
+	kiterrors.ErrCodeTokenExpired:               *HTTPErrTokenExpired,
+	kiterrors.ErrCodeNoCredentialsMatch:         *HTTPErrNoCredentialsMatch,
+	kiterrors.ErrCodeConsumerNotFound:           *HTTPErrConsumerNotFound,
 	kiterrors.ErrCodeForbidden:                  *HTTPErrForbidden,
 	kiterrors.ErrCodeNotFound:                   *HTTPErrNotFound,
 	kiterrors.ErrCodeNotImplemented:             *HTTPErrNotImplemented,
@@ -129,6 +132,9 @@ var httpError2KitErrorMapping = map[int]kiterrors.Error{
 	HTTPErrMultipleTokenProvied.Code:       *kiterrors.ErrMultipleTokenProvied,
 	HTTPErrUnrecognizableToken.Code:        *kiterrors.ErrUnrecognizableToken,
 	HTTPErrTokenBlacklisted.Code:           *kiterrors.ErrTokenBlacklisted,
+	HTTPErrTokenExpired.Code:               *kiterrors.ErrTokenExpired,
+	HTTPErrNoCredentialsMatch.Code:         *kiterrors.ErrNoCredentialsMatch,
+	HTTPErrConsumerNotFound.Code:           *kiterrors.ErrConsumerNotFound,
 	HTTPErrForbidden.Code:                  *kiterrors.ErrForbidden,
 	HTTPErrNotFound.Code:                   *kiterrors.ErrNotFound,
 	HTTPErrNotImplemented.Code:             *kiterrors.ErrNotImplemented,
@@ -185,6 +191,18 @@ var (
 	// blacklisted.
 	HTTPErrTokenBlacklisted = NewHTTPError(http.StatusUnauthorized, 401005, "The token has been blacklisted")
 
+	// HTTPErrTokenExpired is an error which occurres when the token is
+	// expired.
+	HTTPErrTokenExpired = NewHTTPError(http.StatusUnauthorized, 401006, "The token has expired")
+
+	// HTTPErrNoCredentialsMatch is an error which occurres when the
+	// credentials of the request match no known credentials.
+	HTTPErrNoCredentialsMatch = NewHTTPError(http.StatusUnauthorized, 401007, "No credentials match")
+
+	// HTTPErrConsumerNotFound is an error which occurres when no consumer
+	// owns the credentials of the request.
+	HTTPErrConsumerNotFound = NewHTTPError(http.StatusUnauthorized, 401008, "Consumer not found")
+
 	// HTTPErrInsufficientPermission is an error which occurres when a request
 	// does not have permission to access a API.
 	HTTPErrInsufficientPermission = NewHTTPError(http.StatusForbidden, 403050, "Insufficient Permission")
@@ -3,8 +3,11 @@ package http
 import (
 	"context"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/quocdaitrn/golang-kit/constant"
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
 )
 
 // PopulateRequestAuthorizationToken is a RequestFunc that populates scopes values
@@ -12,3 +15,202 @@ import (
 func PopulateRequestAuthorizationToken(ctx context.Context, r *http.Request) context.Context {
 	return context.WithValue(ctx, constant.ContextAuthorization, r.Header.Get(HeaderAuthorization))
 }
+
+// PopulateRequestAPIKey is a RequestFunc that populates the API key from
+// "X-API-Key" header to the context.
+func PopulateRequestAPIKey(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, constant.ContextAPIKey, r.Header.Get(HeaderAPIKey))
+}
+
+// CredentialSource finds credentials in a request.
+type CredentialSource func(r *http.Request) []kitcontext.Credential
+
+// FromHeader finds credentials in the header name. For "Authorization" the
+// value keeps its scheme, as in "Bearer {token}".
+func FromHeader(name string) CredentialSource {
+	return func(r *http.Request) []kitcontext.Credential {
+		var creds []kitcontext.Credential
+		for _, v := range r.Header.Values(name) {
+			creds = append(creds, kitcontext.Credential{Source: kitcontext.CredentialSourceHeader, Name: name, Value: v})
+		}
+		return creds
+	}
+}
+
+// FromCookie finds a credential in the cookie name.
+func FromCookie(name string) CredentialSource {
+	return func(r *http.Request) []kitcontext.Credential {
+		var creds []kitcontext.Credential
+		for _, c := range r.Cookies() {
+			if c.Name == name {
+				creds = append(creds, kitcontext.Credential{Source: kitcontext.CredentialSourceCookie, Name: name, Value: c.Value})
+			}
+		}
+		return creds
+	}
+}
+
+// FromQuery finds a credential in the query parameter name. Tokens in URLs
+// end up in logs, it should only be used where headers and cookies can't be
+// set, such as WebSocket handshakes.
+func FromQuery(name string) CredentialSource {
+	return func(r *http.Request) []kitcontext.Credential {
+		var creds []kitcontext.Credential
+		for _, v := range r.URL.Query()[name] {
+			creds = append(creds, kitcontext.Credential{Source: kitcontext.CredentialSourceQuery, Name: name, Value: v})
+		}
+		return creds
+	}
+}
+
+// PopulateRequestCredentials returns a RequestFunc that populates the non
+// empty credentials found by sources to the context, the "Authorization"
+// header when no source is given. auth.Authenticate rejects requests carrying
+// more than one.
+func PopulateRequestCredentials(sources ...CredentialSource) func(ctx context.Context, r *http.Request) context.Context {
+	if len(sources) == 0 {
+		sources = []CredentialSource{FromHeader(HeaderAuthorization)}
+	}
+
+	return func(ctx context.Context, r *http.Request) context.Context {
+		var creds []kitcontext.Credential
+		for _, source := range sources {
+			for _, c := range source(r) {
+				if c.Value != "" {
+					creds = append(creds, c)
+				}
+			}
+		}
+
+		return kitcontext.WithCredentials(ctx, creds)
+	}
+}
+
+// PopulateRequestClientCertificate is a RequestFunc that populates the client
+// certificate verified during the TLS handshake to the context. Certificates
+// presented but not verified against the client CAs are ignored.
+func PopulateRequestClientCertificate(ctx context.Context, r *http.Request) context.Context {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+
+	return kitcontext.WithClientCertificate(ctx, r.TLS.VerifiedChains[0][0])
+}
+
+// PopulateRequestTarget is a RequestFunc that populates the method and the
+// URI of the request to the context, as needed by Digest authentication.
+func PopulateRequestTarget(ctx context.Context, r *http.Request) context.Context {
+	return kitcontext.WithRequestTarget(ctx, kitcontext.RequestTarget{Method: r.Method, URI: r.RequestURI})
+}
+
+type clientRequestErrorKeyType struct{}
+
+var clientRequestErrorKey = clientRequestErrorKeyType{}
+
+// abortRequest returns a context which makes the go-kit client abort the
+// request with err, since client RequestFuncs can't return errors.
+func abortRequest(ctx context.Context, err error) context.Context {
+	ctx, cancel := context.WithCancelCause(context.WithValue(ctx, clientRequestErrorKey, err))
+	cancel(err)
+
+	return ctx
+}
+
+// ClientRequestError returns the error which made a client RequestFunc of
+// this package abort the request, such as RequestSigner.RequestFunc failing
+// to sign it. The client endpoint fails as well, ClientRequestError lets a
+// ClientFinalizer tell these failures apart to log or report them.
+func ClientRequestError(ctx context.Context) error {
+	err, _ := ctx.Value(clientRequestErrorKey).(error)
+	return err
+}
+
+// TokenSource provides access tokens, such as auth's client credentials token
+// source.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// Bounds of the backoff of SetRequestAuthorizationToken after a failure to
+// obtain a token.
+const (
+	tokenMinBackoff = time.Second
+	tokenMaxBackoff = 30 * time.Second
+)
+
+// SetRequestAuthorizationToken returns a client RequestFunc that sets the
+// "Authorization" header of outgoing requests to a Bearer token of ts, to be
+// used with ClientBefore. It is the client side counterpart of
+// PopulateRequestAuthorizationToken. When no token can be obtained the
+// request is aborted, the error is available to client finalizers with
+// ClientRequestError. The failure is then reused, without asking ts, for a
+// backoff growing from one to 30 seconds.
+func SetRequestAuthorizationToken(ts TokenSource) func(ctx context.Context, r *http.Request) context.Context {
+	var b tokenBackoff
+
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if err := b.failure(); err != nil {
+			return abortRequest(ctx, err)
+		}
+
+		token, err := ts.Token(ctx)
+		if err != nil {
+			// The cancellation of one request must not fail the others.
+			if ctx.Err() == nil {
+				b.fail(err)
+			}
+			return abortRequest(ctx, err)
+		}
+
+		b.succeed()
+		if token != "" {
+			r.Header.Set(HeaderAuthorization, "Bearer "+token)
+		}
+
+		return ctx
+	}
+}
+
+// tokenBackoff remembers the last failure to obtain a token.
+type tokenBackoff struct {
+	mu      sync.Mutex
+	err     error
+	retryAt time.Time
+	backoff time.Duration
+}
+
+// failure returns the last failure until its backoff elapses.
+func (b *tokenBackoff) failure() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil && time.Now().Before(b.retryAt) {
+		return b.err
+	}
+
+	return nil
+}
+
+func (b *tokenBackoff) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.backoff *= 2
+	if b.backoff < tokenMinBackoff {
+		b.backoff = tokenMinBackoff
+	}
+	if b.backoff > tokenMaxBackoff {
+		b.backoff = tokenMaxBackoff
+	}
+
+	b.err = err
+	b.retryAt = time.Now().Add(b.backoff)
+}
+
+func (b *tokenBackoff) succeed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.err = nil
+	b.backoff = 0
+}
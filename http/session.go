@@ -0,0 +1,215 @@
+package http
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	kitcontext "github.com/quocdaitrn/golang-kit/context"
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+var (
+	ErrCookieKeyInvalid   = kiterrors.New("cookie keys must be 32 bytes long")
+	ErrCookieValueInvalid = kiterrors.New("invalid cookie value")
+)
+
+// CookieCodec encrypts and authenticates cookie values with AES-256-GCM, so
+// that clients can neither read nor forge them. Values are bound to the cookie
+// name.
+type CookieCodec struct {
+	aeads []cipher.AEAD
+}
+
+// NewCookieCodec creates a CookieCodec with 32 bytes keys. Values are encoded
+// with the first key and decoded with any of them, so that keys can be rotated
+// by prepending the new one.
+func NewCookieCodec(keys ...[]byte) (*CookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, kiterrors.WithStack(ErrCookieKeyInvalid)
+	}
+
+	c := &CookieCodec{}
+	for _, key := range keys {
+		if len(key) != 32 {
+			return nil, kiterrors.WithStack(ErrCookieKeyInvalid)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, kiterrors.WithStack(err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, kiterrors.WithStack(err)
+		}
+
+		c.aeads = append(c.aeads, aead)
+	}
+
+	return c, nil
+}
+
+// Encode encrypts value of the cookie name.
+func (c *CookieCodec) Encode(name, value string) (string, error) {
+	aead := c.aeads[0]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", kiterrors.WithStack(err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decode decrypts the encoded value of the cookie name.
+func (c *CookieCodec) Decode(name, encoded string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", kiterrors.WithStack(ErrCookieValueInvalid)
+	}
+
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if value, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return string(value), nil
+		}
+	}
+
+	return "", kiterrors.WithStack(ErrCookieValueInvalid)
+}
+
+// SessionCookie transports session tokens in an encrypted cookie, which is
+// HttpOnly, Secure and SameSite=Lax unless configured otherwise.
+type SessionCookie struct {
+	name     string
+	codec    *CookieCodec
+	path     string
+	domain   string
+	maxAge   time.Duration
+	insecure bool
+	sameSite http.SameSite
+}
+
+// SessionCookieOption configures a SessionCookie.
+type SessionCookieOption func(*SessionCookie)
+
+// WithCookiePath sets the path of the cookie, "/" by default.
+func WithCookiePath(path string) SessionCookieOption {
+	return func(c *SessionCookie) {
+		c.path = path
+	}
+}
+
+// WithCookieDomain sets the domain of the cookie, the host of the request by
+// default.
+func WithCookieDomain(domain string) SessionCookieOption {
+	return func(c *SessionCookie) {
+		c.domain = domain
+	}
+}
+
+// WithCookieMaxAge makes the cookie persistent for d. By default it is
+// deleted when the browser is closed. Sessions expire on the server side
+// regardless.
+func WithCookieMaxAge(d time.Duration) SessionCookieOption {
+	return func(c *SessionCookie) {
+		c.maxAge = d
+	}
+}
+
+// WithCookieSameSite sets the SameSite attribute of the cookie.
+func WithCookieSameSite(s http.SameSite) SessionCookieOption {
+	return func(c *SessionCookie) {
+		c.sameSite = s
+	}
+}
+
+// WithInsecureCookie drops the Secure attribute of the cookie, for local
+// development over plain HTTP.
+func WithInsecureCookie() SessionCookieOption {
+	return func(c *SessionCookie) {
+		c.insecure = true
+	}
+}
+
+// NewSessionCookie creates a SessionCookie named name encoded with codec.
+func NewSessionCookie(name string, codec *CookieCodec, opts ...SessionCookieOption) *SessionCookie {
+	c := &SessionCookie{
+		name:     name,
+		codec:    codec,
+		path:     "/",
+		sameSite: http.SameSiteLaxMode,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Name returns the name of the cookie.
+func (c *SessionCookie) Name() string {
+	return c.name
+}
+
+// PopulateRequest is a RequestFunc that populates the session of the cookie
+// to the context as a kitcontext.SessionRef. A cookie which fails to decode
+// is ignored.
+func (c *SessionCookie) PopulateRequest(ctx context.Context, r *http.Request) context.Context {
+	var token string
+	if cookie, err := r.Cookie(c.name); err == nil {
+		token, _ = c.codec.Decode(c.name, cookie.Value)
+	}
+
+	return kitcontext.WithSessionRef(ctx, kitcontext.NewSessionRef(token))
+}
+
+// SetResponse is a ServerResponseFunc that sets the cookie when the session
+// changed during the request, and deletes it when the session ended.
+func (c *SessionCookie) SetResponse(ctx context.Context, w http.ResponseWriter) context.Context {
+	ref := kitcontext.SessionRefFromContext(ctx)
+	if ref == nil || !ref.Changed() {
+		return ctx
+	}
+
+	cookie := &http.Cookie{
+		Name:     c.name,
+		Path:     c.path,
+		Domain:   c.domain,
+		Secure:   !c.insecure,
+		HttpOnly: true,
+		SameSite: c.sameSite,
+	}
+
+	if ref.Token() == "" {
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+		return ctx
+	}
+
+	value, err := c.codec.Encode(c.name, ref.Token())
+	if err != nil {
+		return ctx
+	}
+
+	cookie.Value = value
+	if c.maxAge > 0 {
+		cookie.MaxAge = int(c.maxAge.Seconds())
+	}
+	http.SetCookie(w, cookie)
+
+	return ctx
+}
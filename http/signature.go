@@ -0,0 +1,441 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+	"github.com/quocdaitrn/golang-kit/internal/strutil"
+	"github.com/quocdaitrn/golang-kit/internal/sweep"
+)
+
+// Headers of signed requests.
+const (
+	HeaderSignature          = "X-Signature"
+	HeaderSignatureTimestamp = "X-Signature-Timestamp"
+	HeaderSignatureNonce     = "X-Signature-Nonce"
+	HeaderContentSHA256      = "X-Content-SHA256"
+)
+
+const (
+	defaultSignatureMaxSkew     = 5 * time.Minute
+	defaultSignatureMaxBodySize = 10 << 20
+)
+
+// defaultSignedHeaders are the headers signed by default, besides the method,
+// path, query, timestamp, nonce and body digest.
+var defaultSignedHeaders = []string{"host", "content-type"}
+
+// RequestSigner signs requests with HMAC-SHA256. The signature covers the
+// method, path and query, the signed headers, a timestamp, a nonce and the
+// SHA-256 digest of the body:
+//
+//	X-Signature: keyId=<key id>,headers=<h1;h2>,signature=<base64 signature>
+type RequestSigner struct {
+	keyID   string
+	secret  []byte
+	headers []string
+}
+
+// SignerOption configures a RequestSigner.
+type SignerOption func(*RequestSigner)
+
+// WithSignedHeaders sets the headers covered by the signature, "host" and
+// "content-type" by default.
+func WithSignedHeaders(headers ...string) SignerOption {
+	return func(s *RequestSigner) {
+		s.headers = headers
+	}
+}
+
+// NewRequestSigner creates a RequestSigner signing with the secret of keyID.
+func NewRequestSigner(keyID string, secret []byte, opts ...SignerOption) *RequestSigner {
+	s := &RequestSigner{
+		keyID:   keyID,
+		secret:  secret,
+		headers: defaultSignedHeaders,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	headers := make([]string, 0, len(s.headers))
+	for _, h := range s.headers {
+		headers = append(headers, strings.ToLower(h))
+	}
+	s.headers = headers
+
+	return s
+}
+
+// Sign signs r, reading and restoring its body.
+func (s *RequestSigner) Sign(r *http.Request) error {
+	body, err := readBody(r, -1)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return kiterrors.WithStack(err)
+	}
+
+	digest := sha256.Sum256(body)
+	r.Header.Set(HeaderContentSHA256, hex.EncodeToString(digest[:]))
+	r.Header.Set(HeaderSignatureTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+	r.Header.Set(HeaderSignatureNonce, base64.RawURLEncoding.EncodeToString(nonce))
+
+	sig := signRequest(s.secret, canonicalRequest(r, s.headers))
+	r.Header.Set(HeaderSignature, "keyId="+s.keyID+",headers="+strings.Join(s.headers, ";")+",signature="+sig)
+
+	return nil
+}
+
+// RequestFunc returns a client RequestFunc signing outgoing requests, to be
+// used with ClientBefore. go-kit encodes the request body before running it.
+// A request which fails to be signed is aborted, the error is available to
+// client finalizers with ClientRequestError.
+func (s *RequestSigner) RequestFunc() func(ctx context.Context, r *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if err := s.Sign(r); err != nil {
+			return abortRequest(ctx, err)
+		}
+
+		return ctx
+	}
+}
+
+// NonceStore records the nonces of verified requests to reject replays.
+type NonceStore interface {
+	// Use records nonce and reports false if it was already used. The
+	// record may be dropped after expiresAt.
+	Use(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
+}
+
+// SignatureVerifier verifies requests signed by a RequestSigner.
+type SignatureVerifier struct {
+	keys            map[string][]byte
+	maxSkew         time.Duration
+	maxBodySize     int64
+	nonces          NonceStore
+	requiredHeaders []string
+}
+
+// VerifierOption configures a SignatureVerifier.
+type VerifierOption func(*SignatureVerifier)
+
+// WithSignatureMaxSkew sets how far the timestamp of a request may be from
+// the server clock, 5 minutes by default.
+func WithSignatureMaxSkew(d time.Duration) VerifierOption {
+	return func(v *SignatureVerifier) {
+		v.maxSkew = d
+	}
+}
+
+// WithSignatureMaxBodySize sets the maximum size of the body read to verify
+// its digest, 10MB by default.
+func WithSignatureMaxBodySize(n int64) VerifierOption {
+	return func(v *SignatureVerifier) {
+		v.maxBodySize = n
+	}
+}
+
+// WithNonceStore sets the store rejecting replayed requests, an in-memory
+// store by default.
+func WithNonceStore(s NonceStore) VerifierOption {
+	return func(v *SignatureVerifier) {
+		v.nonces = s
+	}
+}
+
+// WithRequiredSignedHeaders sets the headers a signature must cover, "host"
+// by default.
+func WithRequiredSignedHeaders(headers ...string) VerifierOption {
+	return func(v *SignatureVerifier) {
+		v.requiredHeaders = headers
+	}
+}
+
+// NewSignatureVerifier creates a SignatureVerifier accepting the secrets of
+// keys by key id.
+func NewSignatureVerifier(keys map[string][]byte, opts ...VerifierOption) *SignatureVerifier {
+	v := &SignatureVerifier{
+		keys:            keys,
+		maxSkew:         defaultSignatureMaxSkew,
+		maxBodySize:     defaultSignatureMaxBodySize,
+		requiredHeaders: []string{"host"},
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	if v.nonces == nil {
+		v.nonces = NewMemoryNonceStore()
+	}
+
+	return v
+}
+
+// Verify verifies the signature of r and returns its key id. Failures are
+// reported as ErrUnauthorized.
+func (v *SignatureVerifier) Verify(ctx context.Context, r *http.Request) (keyID string, err error) {
+	params, ok := parseSignatureHeader(r.Header.Get(HeaderSignature))
+	if !ok {
+		return "", kiterrors.ErrUnauthorized.WithDetails("missing or malformed request signature")
+	}
+
+	keyID = params["keyId"]
+	secret, ok := v.keys[keyID]
+	if !ok {
+		return "", kiterrors.ErrUnauthorized.WithDetails("unknown signature key")
+	}
+
+	headers := strings.Split(strings.ToLower(params["headers"]), ";")
+	for _, h := range v.requiredHeaders {
+		if !strutil.Contains(headers, strings.ToLower(h)) {
+			return "", kiterrors.ErrUnauthorized.WithDetails("signature must cover header " + h)
+		}
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(HeaderSignatureTimestamp), 10, 64)
+	if err != nil {
+		return "", kiterrors.ErrUnauthorized.WithDetails("malformed signature timestamp")
+	}
+
+	signedAt := time.Unix(ts, 0)
+	if skew := time.Since(signedAt); skew > v.maxSkew || skew < -v.maxSkew {
+		return "", kiterrors.ErrUnauthorized.WithDetails("signature timestamp out of range")
+	}
+
+	body, err := readBody(r, v.maxBodySize)
+	if err != nil {
+		return "", kiterrors.ErrBadRequest.WithDetails(err)
+	}
+
+	digest := sha256.Sum256(body)
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(digest[:])), []byte(r.Header.Get(HeaderContentSHA256))) != 1 {
+		return "", kiterrors.ErrUnauthorized.WithDetails("body digest mismatch")
+	}
+
+	expected := signRequest(secret, canonicalRequest(r, headers))
+	if !hmac.Equal([]byte(expected), []byte(params["signature"])) {
+		return "", kiterrors.ErrUnauthorized.WithDetails("signature mismatch")
+	}
+
+	nonce := r.Header.Get(HeaderSignatureNonce)
+	if nonce == "" {
+		return "", kiterrors.ErrUnauthorized.WithDetails("missing signature nonce")
+	}
+
+	fresh, err := v.nonces.Use(ctx, keyID+":"+nonce, signedAt.Add(v.maxSkew))
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", kiterrors.ErrUnauthorized.WithDetails("replayed request")
+	}
+
+	return keyID, nil
+}
+
+type signatureResultKeyType struct{}
+
+var signatureResultKey = signatureResultKeyType{}
+
+type signatureResult struct {
+	keyID string
+	err   error
+}
+
+// VerifyRequestSignature returns a RequestFunc verifying the signature of
+// requests and populating the result to the context. RequireRequestSignature
+// rejects the requests which failed.
+func VerifyRequestSignature(v *SignatureVerifier) func(ctx context.Context, r *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		keyID, err := v.Verify(ctx, r)
+		return context.WithValue(ctx, signatureResultKey, signatureResult{keyID: keyID, err: err})
+	}
+}
+
+// RequireRequestSignature returns a middleware rejecting requests whose
+// signature was not verified by VerifyRequestSignature.
+func RequireRequestSignature() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			res, ok := ctx.Value(signatureResultKey).(signatureResult)
+			if !ok {
+				return nil, kiterrors.ErrUnauthorized.WithDetails("request signature not verified")
+			}
+
+			if res.err != nil {
+				return nil, res.err
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// SignatureKeyIDFromContext returns the key id of a verified request
+// signature.
+func SignatureKeyIDFromContext(ctx context.Context) string {
+	res, _ := ctx.Value(signatureResultKey).(signatureResult)
+	if res.err != nil {
+		return ""
+	}
+
+	return res.keyID
+}
+
+// canonicalRequest builds the string to sign of r.
+func canonicalRequest(r *http.Request, headers []string) string {
+	var b strings.Builder
+
+	b.WriteString(r.Method + "\n")
+	b.WriteString(r.URL.EscapedPath() + "\n")
+	b.WriteString(canonicalQuery(r.URL.RawQuery) + "\n")
+	b.WriteString(r.Header.Get(HeaderSignatureTimestamp) + "\n")
+	b.WriteString(r.Header.Get(HeaderSignatureNonce) + "\n")
+
+	for _, h := range headers {
+		var v string
+		if h == "host" {
+			v = r.Host
+			if v == "" {
+				v = r.URL.Host
+			}
+		} else {
+			v = strings.Join(r.Header.Values(h), ",")
+		}
+		b.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+
+	b.WriteString(r.Header.Get(HeaderContentSHA256))
+
+	return b.String()
+}
+
+// canonicalQuery sorts the query parameters, so that proxies reordering them
+// do not break signatures.
+func canonicalQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	params := strings.Split(raw, "&")
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+func signRequest(secret []byte, canonical string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseSignatureHeader parses the key=value pairs of the signature header.
+func parseSignatureHeader(s string) (map[string]string, bool) {
+	if s == "" {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, false
+		}
+		params[k] = v
+	}
+
+	if params["keyId"] == "" || params["headers"] == "" || params["signature"] == "" {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// readBody reads the body of r, up to max bytes when max is not negative,
+// and restores it for the next readers.
+func readBody(r *http.Request, max int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	var reader io.Reader = r.Body
+	if max >= 0 {
+		reader = io.LimitReader(r.Body, max+1)
+	}
+
+	body, err := io.ReadAll(reader)
+	r.Body.Close()
+	if err != nil {
+		return nil, kiterrors.WithStack(err)
+	}
+
+	if max >= 0 && int64(len(body)) > max {
+		return nil, kiterrors.Errorf("request body exceeds %d bytes", max)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return body, nil
+}
+
+// memoryNonceStore is an in-memory NonceStore.
+type memoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	sweeps sweep.Schedule
+}
+
+// NewMemoryNonceStore creates a NonceStore kept in memory. It suits a single
+// instance, replicated services need a shared store.
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+// Use implements NonceStore.
+func (s *memoryNonceStore) Use(_ context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.sweeps.Due(now) {
+		for n, exp := range s.nonces {
+			if now.After(exp) {
+				delete(s.nonces, n)
+			}
+		}
+	}
+
+	if exp, ok := s.nonces[nonce]; ok && !now.After(exp) {
+		return false, nil
+	}
+
+	s.nonces[nonce] = expiresAt
+
+	return true, nil
+}
@@ -0,0 +1,275 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	kiterrors "github.com/quocdaitrn/golang-kit/errors"
+)
+
+// defaultReloadInterval is how often files are checked for changes, at most
+// once per handshake.
+const defaultReloadInterval = 10 * time.Second
+
+var (
+	ErrNoCertificatesInPEM = kiterrors.New("no certificates found in PEM")
+	ErrNoServerCertificate = kiterrors.New("server presented no certificate")
+	ErrServerNameMissing   = kiterrors.New("server name is required to verify the server certificate")
+)
+
+// CertificateReloader serves a key pair loaded from disk and reloads it when
+// the files change, so that renewed certificates are picked up without a
+// restart. A pair which fails to load is ignored and the previous one kept.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertificateReloader loads the key pair of certFile and keyFile.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+func (r *CertificateReloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	cert, stale := r.cert, time.Since(r.checkedAt) > defaultReloadInterval
+	r.mu.RUnlock()
+
+	if stale {
+		_ = r.reloadIfChanged()
+
+		r.mu.RLock()
+		cert = r.cert
+		r.mu.RUnlock()
+	}
+
+	return cert
+}
+
+func (r *CertificateReloader) reloadIfChanged() error {
+	r.mu.Lock()
+	r.checkedAt = time.Now()
+	modTime := r.modTime
+	r.mu.Unlock()
+
+	if latestModTime(r.certFile, r.keyFile).After(modTime) {
+		return r.load()
+	}
+
+	return nil
+}
+
+func (r *CertificateReloader) load() error {
+	modTime := latestModTime(r.certFile, r.keyFile)
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return kiterrors.WithStack(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+
+	return nil
+}
+
+// CertPoolReloader serves a pool of CA certificates loaded from disk and
+// reloads it when the files change.
+type CertPoolReloader struct {
+	files []string
+
+	mu        sync.RWMutex
+	pool      *x509.CertPool
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertPoolReloader loads the PEM certificates of files.
+func NewCertPoolReloader(files ...string) (*CertPoolReloader, error) {
+	r := &CertPoolReloader{files: files}
+
+	pool, err := LoadCertPool(files...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.pool = pool
+	r.modTime = latestModTime(files...)
+	r.checkedAt = time.Now()
+
+	return r, nil
+}
+
+// Pool returns the current pool.
+func (r *CertPoolReloader) Pool() *x509.CertPool {
+	r.mu.RLock()
+	pool, stale := r.pool, time.Since(r.checkedAt) > defaultReloadInterval
+	r.mu.RUnlock()
+
+	if !stale {
+		return pool
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checkedAt = time.Now()
+	if modTime := latestModTime(r.files...); modTime.After(r.modTime) {
+		if p, err := LoadCertPool(r.files...); err == nil {
+			r.pool = p
+			r.modTime = modTime
+		}
+	}
+
+	return r.pool
+}
+
+// LoadCertPool loads the PEM certificates of files into a pool.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, f := range files {
+		pem, err := os.ReadFile(f)
+		if err != nil {
+			return nil, kiterrors.WithStack(err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, kiterrors.Errorf("%s: %s", ErrNoCertificatesInPEM, f)
+		}
+	}
+
+	return pool, nil
+}
+
+// NewServerTLSConfig creates a server tls.Config serving the key pair of
+// certFile and keyFile and, when clientCAFiles are given, requiring client
+// certificates signed by them. Certificates and CAs are reloaded from disk
+// when they change.
+func NewServerTLSConfig(certFile, keyFile string, clientCAFiles ...string) (*tls.Config, error) {
+	certs, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if len(clientCAFiles) == 0 {
+		return cfg, nil
+	}
+
+	cas, err := NewCertPoolReloader(clientCAFiles...)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	cfg.ClientCAs = cas.Pool()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := cfg.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = cas.Pool()
+		return c, nil
+	}
+
+	return cfg, nil
+}
+
+// NewClientTLSConfig creates a client tls.Config presenting the key pair of
+// certFile and keyFile and trusting rootCAFiles, or the system roots when none
+// is given. The key pair and the CAs are reloaded from disk when they change.
+func NewClientTLSConfig(certFile, keyFile string, rootCAFiles ...string) (*tls.Config, error) {
+	certs, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: certs.GetClientCertificate,
+	}
+
+	if len(rootCAFiles) == 0 {
+		return cfg, nil
+	}
+
+	cas, err := NewCertPoolReloader(rootCAFiles...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients have no per connection config, the server certificate is
+	// verified against the current pool by VerifyConnection instead.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		return verifyServerCertificate(cs, cas.Pool())
+	}
+
+	return cfg, nil
+}
+
+// verifyServerCertificate verifies the certificate chain of the server of cs
+// against roots, as crypto/tls does for tls.Config.RootCAs.
+func verifyServerCertificate(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return kiterrors.WithStack(ErrNoServerCertificate)
+	}
+	if cs.ServerName == "" {
+		return kiterrors.WithStack(ErrServerNameMissing)
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(opts)
+
+	return kiterrors.WithStack(err)
+}
+
+// latestModTime returns the latest modification time of files.
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,12 @@
+package strutil
+
+// Contains reports whether s is in list.
+func Contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
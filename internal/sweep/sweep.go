@@ -0,0 +1,24 @@
+package sweep
+
+import "time"
+
+// Interval is how often the in-memory stores sweep their expired entries.
+const Interval = time.Minute
+
+// Schedule limits the sweeping of expired entries by in-memory stores to once
+// per Interval, so that writes don't scan the whole store each time. It must
+// be guarded by the lock of the store.
+type Schedule struct {
+	next time.Time
+}
+
+// Due reports whether the store must be swept at now.
+func (s *Schedule) Due(now time.Time) bool {
+	if now.Before(s.next) {
+		return false
+	}
+
+	s.next = now.Add(Interval)
+
+	return true
+}
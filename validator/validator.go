@@ -1,7 +1,9 @@
 package validator
 
 import (
+	"context"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/go-playground/locales/en"
@@ -17,14 +19,51 @@ type Validator interface {
 	Validate(i interface{}) error
 }
 
+// PasswordPolicy checks passwords for the password tag, auth.PasswordPolicy
+// implements it. Violations maps each violated rule to a message.
+type PasswordPolicy interface {
+	Violations(password string, userInputs ...string) (map[string]string, error)
+}
+
+// Option configures a Validator.
+type Option func(*validator)
+
+// WithPasswordPolicy registers the password tag checking string fields
+// against p. Its optional parameter lists the space separated names of the
+// sibling fields the password must not contain:
+//
+//	Password string `validate:"required,password=Username Email"`
+//
+// The details of a failing field list the violated rules. Errors of the
+// policy, such as an unreadable breached password list, are returned as is.
+func WithPasswordPolicy(p PasswordPolicy) Option {
+	return func(v *validator) {
+		v.passwordPolicy = p
+	}
+}
+
 // validator represents a validator for request data.
 type validator struct {
 	Validator  *stdvalidator.Validate
 	Translator ut.Translator
+
+	passwordPolicy PasswordPolicy
+}
+
+// passwordResultKey is the context key of the passwordResult of the struct
+// being validated.
+type passwordResultKey struct{}
+
+// passwordResult collects the password violations found while validating a
+// struct, by struct field name in the order the fields were validated, and
+// the error of the policy if it failed.
+type passwordResult struct {
+	violations map[string][]map[string]string
+	err        error
 }
 
 // New creates and returns a new instance of Validator.
-func New() (Validator, error) {
+func New(opts ...Option) (Validator, error) {
 	v := stdvalidator.New()
 	// Get name for validator by priorities: header > param > query > json > form.
 	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
@@ -48,12 +87,76 @@ func New() (Validator, error) {
 		return nil, err
 	}
 
-	return &validator{Validator: v, Translator: trans}, nil
+	vv := &validator{Validator: v, Translator: trans}
+	for _, opt := range opts {
+		opt(vv)
+	}
+
+	if vv.passwordPolicy != nil {
+		if err := vv.registerPassword(); err != nil {
+			return nil, err
+		}
+	}
+
+	return vv, nil
+}
+
+// registerPassword registers the password tag and its translation.
+func (v *validator) registerPassword() error {
+	err := v.Validator.RegisterValidationCtx("password", func(ctx context.Context, fl stdvalidator.FieldLevel) bool {
+		password := fl.Field().String()
+
+		var inputs []string
+		for _, name := range strings.Fields(fl.Param()) {
+			if f := fl.Parent().FieldByName(name); f.IsValid() && f.Kind() == reflect.String {
+				inputs = append(inputs, f.String())
+			}
+		}
+
+		res, _ := ctx.Value(passwordResultKey{}).(*passwordResult)
+
+		violations, err := v.passwordPolicy.Violations(password, inputs...)
+		if err != nil {
+			if res != nil && res.err == nil {
+				res.err = err
+			}
+			return true
+		}
+		if len(violations) == 0 {
+			return true
+		}
+
+		if res != nil {
+			name := fl.StructFieldName()
+			res.violations[name] = append(res.violations[name], violations)
+		}
+
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	return v.Validator.RegisterTranslation("password", v.Translator,
+		func(ut ut.Translator) error {
+			return ut.Add("password", "{0} does not meet the password policy", true)
+		},
+		func(ut ut.Translator, fe stdvalidator.FieldError) string {
+			t, _ := ut.T("password", fe.Field())
+			return t
+		},
+	)
 }
 
 // Validate validate input struct.
 func (v *validator) Validate(i interface{}) error {
-	err := v.Validator.Struct(i)
+	pr := &passwordResult{violations: map[string][]map[string]string{}}
+	ctx := context.WithValue(context.Background(), passwordResultKey{}, pr)
+
+	err := v.Validator.StructCtx(ctx, i)
+	if pr.err != nil {
+		return pr.err
+	}
 	if err != nil {
 		details := map[string]string{}
 		ves := err.(stdvalidator.ValidationErrors)
@@ -64,6 +167,13 @@ func (v *validator) Validate(i interface{}) error {
 
 			if _, ok := details[ns]; !ok {
 				details[ns] = ve.Translate(v.Translator)
+
+				if ve.Tag() == "password" {
+					if pv := pr.violations[ve.StructField()]; len(pv) > 0 {
+						details[ns] += ": " + joinViolations(pv[0])
+						pr.violations[ve.StructField()] = pv[1:]
+					}
+				}
 			}
 		}
 
@@ -72,3 +182,19 @@ func (v *validator) Validate(i interface{}) error {
 
 	return nil
 }
+
+// joinViolations joins password violation messages in the order of their rule.
+func joinViolations(violations map[string]string) string {
+	rules := make([]string, 0, len(violations))
+	for rule := range violations {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	msgs := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		msgs = append(msgs, violations[rule])
+	}
+
+	return strings.Join(msgs, ", ")
+}